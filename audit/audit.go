@@ -0,0 +1,298 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+)
+
+// 审计日志文件，当前写入的文件为audit.jsonl，切分后的文件按切分时间命名，如audit-20230301-000000.jsonl
+const (
+	currentFile   = "audit.jsonl"
+	rotatedPrefix = "audit-"
+	rotatedSuffix = ".jsonl"
+	rotatedLayout = "20060102-150405"
+)
+
+// 聊天类型
+const (
+	ChatPrivate = "private"
+	ChatGroup   = "group"
+)
+
+// Record 一次问答的审计记录，每行一条JSON
+type Record struct {
+	// 收到消息的时间
+	Time time.Time `json:"time"`
+	// 请求ID，和日志中的request_id相同
+	RequestID string `json:"request_id"`
+	// 微信消息ID
+	MsgID string `json:"msg_id"`
+	// 聊天类型：private、group
+	ChatType string `json:"chat_type"`
+	// 私聊为好友昵称，群聊为群名称
+	Chat string `json:"chat"`
+	// 发送者，群聊中优先使用群昵称
+	Sender string `json:"sender"`
+	// 用户发送的原始消息
+	Question string `json:"question"`
+	// 机器人发送的回复
+	Reply string `json:"reply"`
+	// 请求GPT使用的模型，没有请求GPT时为空
+	Model string `json:"model,omitempty"`
+	// 提问的token数，估算值
+	PromptTokens int `json:"prompt_tokens,omitempty"`
+	// 回复的token数，估算值
+	CompletionTokens int `json:"completion_tokens,omitempty"`
+	// 请求GPT的耗时，单位毫秒
+	GptLatency int64 `json:"gpt_latency,omitempty"`
+	// 从收到消息到发送完回复的耗时，单位毫秒
+	Latency int64 `json:"latency"`
+	// 请求GPT或者发送回复的错误
+	Error string `json:"error,omitempty"`
+}
+
+// Filter 导出审计记录的条件，为空的条件不过滤
+type Filter struct {
+	// 开始时间，包含
+	Since time.Time
+	// 结束时间，不包含
+	Until time.Time
+	// 群名称，只导出这个群的记录
+	Group string
+	// 发送者，只导出这个用户的记录
+	User string
+}
+
+// Match 记录是否满足条件
+func (f Filter) Match(record Record) bool {
+	if !f.Since.IsZero() && record.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !record.Time.Before(f.Until) {
+		return false
+	}
+	if f.Group != "" && (record.ChatType != ChatGroup || record.Chat != f.Group) {
+		return false
+	}
+	if f.User != "" && record.Sender != f.User {
+		return false
+	}
+	return true
+}
+
+// writer 当前打开的审计日志文件
+type writer struct {
+	dir    string
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+var (
+	current writer
+	lock    sync.Mutex
+)
+
+// Write 追加一条审计记录，需要时先切分文件，失败只打印日志，不影响回复
+func Write(record Record) {
+	cfg := config.LoadConfig()
+	if !cfg.Audit.Enable {
+		return
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		logger.Warning(fmt.Sprintf("marshal audit record error: %v", err))
+		return
+	}
+	data = append(data, '\n')
+
+	lock.Lock()
+	defer lock.Unlock()
+	if err = current.prepare(cfg, int64(len(data))); err != nil {
+		logger.Warning(fmt.Sprintf("open audit log error: %v", err))
+		return
+	}
+	n, err := current.file.Write(data)
+	current.size += int64(n)
+	if err != nil {
+		logger.Warning(fmt.Sprintf("write audit log error: %v", err))
+	}
+}
+
+// Close 关闭当前的审计日志文件，退出前调用
+func Close() {
+	lock.Lock()
+	defer lock.Unlock()
+	current.close()
+}
+
+// prepare 打开审计日志文件，目录变化时重新打开，超过大小或者跨天时切分
+func (w *writer) prepare(cfg *config.Configuration, size int64) error {
+	dir := cfg.AuditDir()
+	if w.file != nil && w.dir != dir {
+		w.close()
+	}
+	if w.file == nil {
+		if err := w.open(dir); err != nil {
+			return err
+		}
+	}
+
+	maxSize := int64(cfg.Audit.MaxSize) * 1024 * 1024
+	now := time.Now()
+	if w.size == 0 || !(maxSize > 0 && w.size+size > maxSize || cfg.Audit.Daily && !sameDay(w.opened, now)) {
+		return nil
+	}
+	w.close()
+	if err := os.Rename(filepath.Join(dir, currentFile), rotatedPath(dir, now)); err != nil {
+		return err
+	}
+	cleanup(dir, cfg.Audit.MaxAge, cfg.Audit.MaxBackups)
+	return w.open(dir)
+}
+
+// open 打开当前文件，已经存在时继续追加，使用文件的修改时间判断是否跨天
+func (w *writer) open(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, currentFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.dir, w.file, w.size, w.opened = dir, file, info.Size(), time.Now()
+	if info.Size() > 0 {
+		w.opened = info.ModTime()
+	}
+	return nil
+}
+
+// close 关闭当前文件
+func (w *writer) close() {
+	if w.file != nil {
+		_ = w.file.Close()
+	}
+	w.file, w.size = nil, 0
+}
+
+// cleanup 按保留天数和保留个数删除切分后的文件
+func cleanup(dir string, maxAge, maxBackups int) {
+	files := rotatedFiles(dir)
+	cutoff := time.Now().AddDate(0, 0, -maxAge)
+	for i, path := range files {
+		expired := maxAge > 0 && rotatedTime(path).Before(cutoff)
+		excess := maxBackups > 0 && i < len(files)-maxBackups
+		if !expired && !excess {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			logger.Warning(fmt.Sprintf("remove audit log error: %v", err))
+		}
+	}
+}
+
+// rotatedPath 切分后的文件路径，同一秒内多次切分时在时间后面加序号，如audit-20230301-120000-1.jsonl，避免覆盖已有文件
+func rotatedPath(dir string, now time.Time) string {
+	name := rotatedPrefix + now.Format(rotatedLayout)
+	path := filepath.Join(dir, name+rotatedSuffix)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s-%d%s", name, i, rotatedSuffix))
+	}
+}
+
+// rotatedFiles 切分后的文件，按切分时间和序号从早到晚排序
+func rotatedFiles(dir string) []string {
+	files, _ := filepath.Glob(filepath.Join(dir, rotatedPrefix+"*"+rotatedSuffix))
+	sort.Slice(files, func(i, j int) bool {
+		ti, si := rotatedStamp(files[i])
+		tj, sj := rotatedStamp(files[j])
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return si < sj
+	})
+	return files
+}
+
+// rotatedTime 从文件名解析切分时间，解析失败时返回零值
+func rotatedTime(path string) time.Time {
+	t, _ := rotatedStamp(path)
+	return t
+}
+
+// rotatedStamp 从文件名解析切分时间和同一秒内的序号，没有序号时为0，解析失败时时间为零值
+func rotatedStamp(path string) (time.Time, int) {
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), rotatedPrefix), rotatedSuffix)
+	if len(name) < len(rotatedLayout) {
+		return time.Time{}, 0
+	}
+	t, _ := time.ParseInLocation(rotatedLayout, name[:len(rotatedLayout)], time.Local)
+	seq, _ := strconv.Atoi(strings.TrimPrefix(name[len(rotatedLayout):], "-"))
+	return t, seq
+}
+
+// sameDay 两个时间是否在同一天
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// Read 按时间顺序读取审计目录中满足条件的记录，包括切分后的文件，无法解析的行跳过
+func Read(dir string, filter Filter) ([]Record, error) {
+	files := rotatedFiles(dir)
+	// 切分时间早于开始时间的文件中不会有需要的记录
+	if !filter.Since.IsZero() {
+		for len(files) > 0 && rotatedTime(files[0]).Before(filter.Since) {
+			files = files[1:]
+		}
+	}
+	files = append(files, filepath.Join(dir, currentFile))
+
+	records := make([]Record, 0)
+	for _, path := range files {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var record Record
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				continue
+			}
+			if filter.Match(record) {
+				records = append(records, record)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read %s error: %v", path, err)
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,205 @@
+package audit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+// writeRecords 把记录写入审计目录下的文件
+func writeRecords(t *testing.T, path string, records ...Record) {
+	t.Helper()
+	var builder strings.Builder
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder.Write(data)
+		builder.WriteString("\n")
+	}
+	if err := ioutil.WriteFile(path, []byte(builder.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotateWithinSameSecond(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Configuration{Audit: config.AuditConfig{Dir: dir, MaxSize: 1}}
+
+	// 每条记录超过最大大小的一半，每次写入前都会切分
+	const writes = 4
+	w := &writer{}
+	defer w.close()
+	for i := 0; i < writes; i++ {
+		data, _ := json.Marshal(Record{MsgID: string(rune('a' + i)), Question: strings.Repeat("x", 600*1024)})
+		data = append(data, '\n')
+		if err := w.prepare(cfg, int64(len(data))); err != nil {
+			t.Fatal(err)
+		}
+		n, err := w.file.Write(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.size += int64(n)
+	}
+
+	files := rotatedFiles(dir)
+	if len(files) != writes-1 {
+		t.Fatalf("rotated files = %v, want %d files", files, writes-1)
+	}
+	records, err := Read(dir, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.MsgID)
+	}
+	if !reflect.DeepEqual(ids, []string{"a", "b", "c", "d"}) {
+		t.Errorf("records read in order %v, rotated files %v", ids, files)
+	}
+}
+
+func TestRotatedPathUnique(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2023, 3, 1, 12, 0, 0, 0, time.Local)
+	want := []string{"audit-20230301-120000.jsonl", "audit-20230301-120000-1.jsonl", "audit-20230301-120000-2.jsonl"}
+	for _, name := range want {
+		path := rotatedPath(dir, now)
+		if filepath.Base(path) != name {
+			t.Fatalf("rotated path = %s, want %s", filepath.Base(path), name)
+		}
+		if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if !rotatedTime(path).Equal(now) {
+			t.Errorf("rotated time of %s = %v", name, rotatedTime(path))
+		}
+	}
+
+	// 同一秒内的文件按序号排序，排在下一秒之前
+	if err := ioutil.WriteFile(filepath.Join(dir, "audit-20230301-120001.jsonl"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, path := range rotatedFiles(dir) {
+		names = append(names, filepath.Base(path))
+	}
+	if expected := append(want, "audit-20230301-120001.jsonl"); !reflect.DeepEqual(names, expected) {
+		t.Errorf("rotated files = %v, want %v", names, expected)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	now := time.Now()
+	day := func(days int) string {
+		return rotatedPrefix + now.AddDate(0, 0, -days).Format(rotatedLayout) + rotatedSuffix
+	}
+	tests := []struct {
+		name               string
+		maxAge, maxBackups int
+		files, want        []string
+	}{
+		{
+			name:   "expired files removed",
+			maxAge: 7,
+			files:  []string{day(10), day(8), day(3), day(1)},
+			want:   []string{day(3), day(1)},
+		},
+		{
+			name:       "excess backups removed",
+			maxBackups: 2,
+			files:      []string{day(10), day(8), day(3), day(1)},
+			want:       []string{day(3), day(1)},
+		},
+		{
+			name:       "both limits",
+			maxAge:     9,
+			maxBackups: 3,
+			files:      []string{day(20), day(10), day(8), day(3), day(1)},
+			want:       []string{day(8), day(3), day(1)},
+		},
+		{
+			name:  "no limits",
+			files: []string{day(100), day(1)},
+			want:  []string{day(100), day(1)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range append(test.files, currentFile) {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cleanup(dir, test.maxAge, test.maxBackups)
+
+			var names []string
+			for _, path := range rotatedFiles(dir) {
+				names = append(names, filepath.Base(path))
+			}
+			if !reflect.DeepEqual(names, test.want) {
+				t.Errorf("remaining files = %v, want %v", names, test.want)
+			}
+			if _, err := os.Stat(filepath.Join(dir, currentFile)); err != nil {
+				t.Errorf("current file removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestReadFilter(t *testing.T) {
+	dir := t.TempDir()
+	at := func(day, hour int) time.Time {
+		return time.Date(2023, 3, day, hour, 0, 0, 0, time.Local)
+	}
+	writeRecords(t, filepath.Join(dir, rotatedPrefix+at(2, 0).Format(rotatedLayout)+rotatedSuffix),
+		Record{MsgID: "1", Time: at(1, 10), ChatType: ChatGroup, Chat: "群1", Sender: "张三"},
+		Record{MsgID: "2", Time: at(1, 20), ChatType: ChatPrivate, Chat: "张三", Sender: "张三"},
+	)
+	writeRecords(t, filepath.Join(dir, currentFile),
+		Record{MsgID: "3", Time: at(2, 10), ChatType: ChatGroup, Chat: "群1", Sender: "李四"},
+		Record{MsgID: "4", Time: at(2, 11), ChatType: ChatGroup, Chat: "群2", Sender: "张三"},
+		Record{MsgID: "5", Time: at(3, 10), ChatType: ChatGroup, Chat: "群1", Sender: "张三"},
+	)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"all", Filter{}, []string{"1", "2", "3", "4", "5"}},
+		{"group", Filter{Group: "群1"}, []string{"1", "3", "5"}},
+		{"private chat with the same name is not the group", Filter{Group: "张三"}, nil},
+		{"user", Filter{User: "张三"}, []string{"1", "2", "4", "5"}},
+		{"since", Filter{Since: at(2, 0)}, []string{"3", "4", "5"}},
+		{"until", Filter{Until: at(2, 10)}, []string{"1", "2"}},
+		{"time range", Filter{Since: at(1, 20), Until: at(3, 0)}, []string{"2", "3", "4"}},
+		{"group and time range", Filter{Group: "群1", Since: at(2, 0), Until: at(3, 0)}, []string{"3"}},
+		{"group and user", Filter{Group: "群1", User: "张三"}, []string{"1", "5"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			records, err := Read(dir, test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, record := range records {
+				ids = append(ids, record.MsgID)
+			}
+			if !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("records = %v, want %v", ids, test.want)
+			}
+		})
+	}
+}
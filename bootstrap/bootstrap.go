@@ -3,44 +3,175 @@ package bootstrap
 import (
 	"fmt"
 	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/audit"
+	"github.com/qingconglaixueit/wechatbot/config"
 	"github.com/qingconglaixueit/wechatbot/handlers"
+	"github.com/qingconglaixueit/wechatbot/knowledge"
 	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/pkg/metrics"
+	"github.com/qingconglaixueit/wechatbot/server"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
 )
 
-func Run() {
+// sessionSaveInterval 保存会话快照的间隔
+const sessionSaveInterval = time.Minute
+
+// Run 登录微信并开始处理消息，配置有问题、登录失败等无法运行时返回错误，进程以非0状态退出
+func Run() error {
+	// 配置有问题时直接退出，避免运行后才出现难以排查的错误
+	if problems := config.Check(); len(problems) > 0 {
+		for _, problem := range problems {
+			logger.Danger(fmt.Sprintf("config error: %s", problem))
+		}
+		return fmt.Errorf("found %d config problems", len(problems))
+	}
+
+	// 按配置设置日志，配置修改后立即生效
+	cfg := config.LoadConfig()
+	setLogger(cfg)
+	config.Subscribe(func(old, cfg *config.Configuration) {
+		setLogger(cfg)
+	})
+
 	//bot := openwechat.DefaultBot()
 	bot := openwechat.DefaultBot(openwechat.Desktop) // 桌面模式，上面登录不上的可以尝试切换这种模式
 
+	// 监听配置文件修改以及SIGHUP信号，热更新配置
+	config.Watch()
+
+	// 启动HTTP监听提供监控指标和健康检查，登录之前启动，等待扫码时也能看到登录状态
+	if err := server.Start(); err != nil {
+		return fmt.Errorf("server.Start error: %v", err)
+	}
+	config.Subscribe(func(old, cfg *config.Configuration) {
+		if old.HTTP.Listen != cfg.HTTP.Listen {
+			logger.Warning("config http.listen changed, restart to take effect")
+		}
+	})
+
+	// 加载知识库，文档较多时获取向量比较耗时，不阻塞登录，知识库配置修改后重新加载
+	go loadKnowledge()
+	config.Subscribe(func(old, cfg *config.Configuration) {
+		if !reflect.DeepEqual(old.Knowledge, cfg.Knowledge) {
+			go loadKnowledge()
+		}
+	})
+
 	// 注册消息处理函数
 	handler, err := handlers.NewHandler()
 	if err != nil {
-		logger.Danger(fmt.Sprintf("handlers.NewHandler error: %v", err))
-		return
+		return fmt.Errorf("handlers.NewHandler error: %v", err)
 	}
 	bot.MessageHandler = handler
 
 	// 注册登陆二维码回调
 	bot.UUIDCallback = openwechat.PrintlnQrcodeUrl
 
-	// 创建热存储容器对象
-	reloadStorage := openwechat.NewJsonFileHotReloadStorage("storage.json")
+	// 掉线或者在手机上退出登录时更新登录状态
+	bot.LogoutCallBack = func(bot *openwechat.Bot) {
+		server.SetLoginState(server.LoginOffline)
+		logger.Warning("wechat logged out")
+	}
+
+	// 创建热存储容器对象，登录状态文件可以通过--storage指定，多个实例使用不同的文件
+	storage := cfg.StoragePath()
+	if err = os.MkdirAll(filepath.Dir(storage), 0755); err != nil {
+		return fmt.Errorf("create data dir error: %v", err)
+	}
+	reloadStorage := openwechat.NewJsonFileHotReloadStorage(storage)
+
+	// 登录前加载上次运行时已处理的消息ID，登录后微信推送的积压消息不会重复回复
+	if err = handlers.LoadHandledMessages(cfg.DataPath(handledFile)); err != nil {
+		logger.Warning(fmt.Sprintf("load handled messages error: %v", err))
+	}
 
 	// 执行热登录
 	err = bot.HotLogin(reloadStorage)
 	if err != nil {
-		if err := os.Remove("storage.json"); err != nil {
-			logger.Warning(fmt.Sprintf("os.Remove storage.json error: %v", err))
-			return
+		if err := os.Remove(storage); err != nil {
+			return fmt.Errorf("os.Remove %s error: %v", storage, err)
 		}
-		reloadStorage := openwechat.NewJsonFileHotReloadStorage("storage.json")
+		reloadStorage := openwechat.NewJsonFileHotReloadStorage(storage)
 		err = bot.HotLogin(reloadStorage)
 		if err != nil {
-			logger.Warning(fmt.Sprintf("bot.HotLogin error: %v", err))
-			return
+			metrics.Errors.Inc("login_error")
+			return fmt.Errorf("bot.HotLogin error: %v", err)
 		}
 	}
+	server.SetLoginState(server.LoginOnline)
+
+	// 启动定时群聊摘要
+	self, err := bot.GetCurrentUser()
+	if err != nil {
+		return fmt.Errorf("bot.GetCurrentUser error: %v", err)
+	}
+	handlers.StartDigestScheduler(self)
 
-	// 阻塞主goroutine, 直到发生异常或者用户主动退出
-	_ = bot.Block()
+	// 定时保存会话快照供export-sessions导出，同时保存已处理的消息ID
+	go func() {
+		ticker := time.NewTicker(sessionSaveInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			saveSessions()
+			saveHandledMessages()
+		}
+	}()
+
+	// 收到停止信号时主动退出，让下面的Block返回，停止前保存会话快照和已处理的消息ID
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		logger.Info(fmt.Sprintf("received %s, exit", sig))
+		bot.Exit()
+	}()
+
+	// 阻塞主goroutine, 直到发生异常或者用户主动退出，退出前再保存一次会话快照和已处理的消息ID
+	err = bot.Block()
+	server.SetLoginState(server.LoginOffline)
+	saveSessions()
+	saveHandledMessages()
+	audit.Close()
+	if err != nil {
+		return fmt.Errorf("bot.Block error: %v", err)
+	}
+	return nil
+}
+
+// saveSessions 保存会话快照
+func saveSessions() {
+	if err := handlers.SaveSessions(config.LoadConfig().DataPath(sessionsFile)); err != nil {
+		logger.Warning(fmt.Sprintf("save sessions error: %v", err))
+	}
+}
+
+// saveHandledMessages 保存已处理的消息ID
+func saveHandledMessages() {
+	if err := handlers.SaveHandledMessages(config.LoadConfig().DataPath(handledFile)); err != nil {
+		logger.Warning(fmt.Sprintf("save handled messages error: %v", err))
+	}
+}
+
+// setLogger 设置日志级别、格式，api_key在日志中自动隐藏
+func setLogger(cfg *config.Configuration) {
+	if err := logger.SetLevel(cfg.LogLevel); err != nil {
+		logger.Warning(fmt.Sprintf("set log level error: %v", err))
+	}
+	if err := logger.SetFormat(cfg.LogFormat); err != nil {
+		logger.Warning(fmt.Sprintf("set log format error: %v", err))
+	}
+	logger.SetContent(cfg.LogContent)
+	logger.SetSecrets(cfg.ApiKey)
+}
+
+// loadKnowledge 加载知识库
+func loadKnowledge() {
+	if err := knowledge.Load(); err != nil {
+		logger.Warning(fmt.Sprintf("knowledge.Load error: %v", err))
+	}
 }
@@ -0,0 +1,247 @@
+package bootstrap
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/audit"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/handlers"
+)
+
+// 数据目录下的文件
+const (
+	// sessionsFile 会话快照文件
+	sessionsFile = "sessions.json"
+	// handledFile 已处理的消息ID，重启后加载用于去重
+	handledFile = "handled_messages.json"
+)
+
+// CheckConfig 检查配置并打印所有问题，返回进程退出码
+func CheckConfig() int {
+	problems := config.Check()
+	if len(problems) == 0 {
+		fmt.Println("config ok")
+		return 0
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	fmt.Printf("found %d config problems\n", len(problems))
+	return 1
+}
+
+// PrintConfig 打印合并后生效的配置，返回进程退出码
+func PrintConfig() int {
+	data, err := config.Effective()
+	if err != nil {
+		fmt.Printf("print config error: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(data))
+	return 0
+}
+
+// Logout 退出微信登录并删除登录状态文件，下次启动需要重新扫码
+func Logout() int {
+	storage := config.LoadConfig().StoragePath()
+	if _, err := os.Stat(storage); os.IsNotExist(err) {
+		fmt.Printf("%s not found, not logged in\n", storage)
+		return 0
+	}
+
+	// 登录状态还有效时通知微信退出，失效时只删除文件
+	// 文件为空或者无法解析时HotLogin会转为扫码登录，所以先确认能读取登录状态
+	if _, err := openwechat.NewHotReloadStorageItem(openwechat.NewJsonFileHotReloadStorage(storage)); err != nil {
+		fmt.Printf("load %s error: %v, remove it only\n", storage, err)
+	} else {
+		bot := openwechat.DefaultBot(openwechat.Desktop)
+		if err = bot.HotLogin(openwechat.NewJsonFileHotReloadStorage(storage)); err == nil {
+			if err = bot.Logout(); err != nil {
+				fmt.Printf("logout error: %v\n", err)
+			}
+		}
+	}
+	if err := os.Remove(storage); err != nil {
+		fmt.Printf("remove %s error: %v\n", storage, err)
+		return 1
+	}
+	fmt.Println("logged out")
+	return 0
+}
+
+// ExportSessions 导出最近一次保存的会话快照，output为空时输出到标准输出，format为json或text
+func ExportSessions(output, format string) int {
+	data, err := ioutil.ReadFile(config.LoadConfig().DataPath(sessionsFile))
+	if err != nil {
+		fmt.Printf("read sessions error: %v\n", err)
+		return 1
+	}
+	var snapshot handlers.SessionSnapshot
+	if err = json.Unmarshal(data, &snapshot); err != nil {
+		fmt.Printf("decode sessions error: %v\n", err)
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			fmt.Printf("create %s error: %v\n", output, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(snapshot)
+	case "text":
+		fmt.Fprintf(w, "saved at %s, %d sessions\n", snapshot.SavedAt.Format("2006-01-02 15:04:05"), len(snapshot.Sessions))
+		for _, session := range snapshot.Sessions {
+			fmt.Fprintf(w, "\n== %s (%s) ==\n", session.Key, session.Type)
+			for _, message := range session.Messages {
+				_, err = fmt.Fprintf(w, "%s: %s\n", message.Role, message.Content)
+			}
+		}
+	default:
+		fmt.Printf("unknown format %q, use json or text\n", format)
+		return 1
+	}
+	if err != nil {
+		fmt.Printf("export sessions error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// ExportAudit 按日期、群、用户导出审计记录，since、until为日期，如2023-03-01，until当天的记录也导出
+func ExportAudit(since, until, group, user, output, format string) int {
+	// 1.解析条件
+	filter := audit.Filter{Group: group, User: user}
+	for _, date := range []struct {
+		text   string
+		target *time.Time
+		days   int
+	}{{since, &filter.Since, 0}, {until, &filter.Until, 1}} {
+		if date.text == "" {
+			continue
+		}
+		t, err := time.ParseInLocation("2006-01-02", date.text, time.Local)
+		if err != nil {
+			fmt.Printf("invalid date %q, use like 2023-03-01\n", date.text)
+			return 1
+		}
+		*date.target = t.AddDate(0, 0, date.days)
+	}
+	if format != "jsonl" && format != "csv" {
+		fmt.Printf("unknown format %q, use jsonl or csv\n", format)
+		return 1
+	}
+	records, err := audit.Read(config.LoadConfig().AuditDir(), filter)
+	if err != nil {
+		fmt.Printf("read audit log error: %v\n", err)
+		return 1
+	}
+
+	// 2.导出
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			fmt.Printf("create %s error: %v\n", output, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	if format == "jsonl" {
+		encoder := json.NewEncoder(w)
+		for _, record := range records {
+			if err = encoder.Encode(record); err != nil {
+				break
+			}
+		}
+	} else {
+		writer := csv.NewWriter(w)
+		_ = writer.Write([]string{"time", "request_id", "msg_id", "chat_type", "chat", "sender", "question", "reply",
+			"model", "prompt_tokens", "completion_tokens", "gpt_latency", "latency", "error"})
+		for _, record := range records {
+			_ = writer.Write([]string{record.Time.Format(time.RFC3339), record.RequestID, record.MsgID, record.ChatType,
+				record.Chat, record.Sender, record.Question, record.Reply, record.Model,
+				strconv.Itoa(record.PromptTokens), strconv.Itoa(record.CompletionTokens),
+				strconv.FormatInt(record.GptLatency, 10), strconv.FormatInt(record.Latency, 10), record.Error})
+		}
+		writer.Flush()
+		err = writer.Error()
+	}
+	if err != nil {
+		fmt.Printf("export audit log error: %v\n", err)
+		return 1
+	}
+	if output != "" {
+		fmt.Printf("exported %d records to %s\n", len(records), output)
+	}
+	return 0
+}
+
+// UsageReport 按天和模型统计最近days天的请求次数和token用量
+func UsageReport(days int) int {
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), now.Day()-days+1, 0, 0, 0, 0, now.Location())
+	usages, err := gpt.ReadUsage(since)
+	if err != nil {
+		fmt.Printf("read usage error: %v\n", err)
+		return 1
+	}
+
+	type row struct {
+		day, model                               string
+		requests, promptTokens, completionTokens int
+	}
+	rows := make(map[string]*row)
+	total := &row{day: "total"}
+	for _, usage := range usages {
+		day := usage.Time.Local().Format("2006-01-02")
+		key := day + " " + usage.Model
+		if rows[key] == nil {
+			rows[key] = &row{day: day, model: usage.Model}
+		}
+		for _, r := range []*row{rows[key], total} {
+			r.requests++
+			r.promptTokens += usage.PromptTokens
+			r.completionTokens += usage.CompletionTokens
+		}
+	}
+	keys := make([]string, 0, len(rows))
+	for key := range rows {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DAY\tMODEL\tREQUESTS\tPROMPT\tCOMPLETION\tTOTAL")
+	for _, key := range append(keys, "") {
+		r := total
+		if key != "" {
+			r = rows[key]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\n", r.day, r.model, r.requests, r.promptTokens, r.completionTokens, r.promptTokens+r.completionTokens)
+	}
+	_ = w.Flush()
+	fmt.Println("token数为估算值")
+	return 0
+}
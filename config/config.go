@@ -1,24 +1,20 @@
 package config
 
 import (
-	"encoding/json"
-	"fmt"
-	"os"
-	"strconv"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/qingconglaixueit/wechatbot/pkg/logger"
 )
 
 // Configuration 项目配置
 type Configuration struct {
-	// gpt apikey
-	ApiKey string `json:"api_key"`
+	// gpt apikey，可以写file:/run/secrets/openai从文件读取，或者env:NAME从环境变量读取
+	ApiKey string `json:"api_key" secret:"true"`
 	// 自动通过好友
 	AutoPass bool `json:"auto_pass"`
-	// 会话超时时间
-	SessionTimeout time.Duration `json:"session_timeout"`
+	// 会话超时时间，可以是秒数或者"10m"这样的时间
+	SessionTimeout Duration `json:"session_timeout"`
 	// GPT请求最大字符数
 	MaxTokens uint `json:"max_tokens"`
 	// GPT模型
@@ -29,93 +25,347 @@ type Configuration struct {
 	ReplyPrefix string `json:"reply_prefix"`
 	// 清空会话口令
 	SessionClearToken string `json:"session_clear_token"`
+	// 默认开启全群共享会话的群名称
+	SharedSessionGroups []string `json:"shared_session_groups"`
+	// 每个群保留的最近消息条数，用于生成群聊摘要
+	GroupBufferSize int `json:"group_buffer_size"`
+	// 工作时间开始，整点小时
+	WorkStartHour int `json:"work_start_hour"`
+	// 工作时间结束，整点小时
+	WorkEndHour int `json:"work_end_hour"`
+	// 定时发送群聊摘要
+	DigestSchedules []DigestSchedule `json:"digest_schedules"`
+	// 群聊不需要@机器人的触发规则
+	GroupTriggers []GroupTrigger `json:"group_triggers"`
+	// FAQ规则文件，匹配到的问题直接回复，不请求GPT
+	FaqFile string `json:"faq_file"`
+	// 知识库
+	Knowledge KnowledgeConfig `json:"knowledge"`
+	// 新成员入群欢迎
+	Welcome WelcomeConfig `json:"welcome"`
+	// 好友申请验证
+	FriendRequest FriendRequestConfig `json:"friend_request"`
+	// 已处理消息ID的保留时间，单位秒，保留时间内重复推送的消息不再处理
+	DedupTTL int `json:"dedup_ttl"`
+	// 消息时效，单位秒，超过时效的消息视为积压消息
+	StaleWindow int64 `json:"stale_window"`
+	// 是否回复积压消息，否则直接丢弃
+	AnswerBacklog bool `json:"answer_backlog"`
+	// 积压消息最长回复时间，单位秒，超过的积压消息总是丢弃
+	BacklogWindow int64 `json:"backlog_window"`
+	// 回复积压消息时的致歉前缀
+	BacklogPrefix string `json:"backlog_prefix"`
+	// 同时处理消息的worker数量
+	Workers int `json:"workers"`
+	// 每个会话最多排队的需要回复的消息数，群里没有@机器人的消息不计入
+	QueueDepth int `json:"queue_depth"`
+	// 所有会话最多排队的需要回复的消息数
+	QueueSize int `json:"queue_size"`
+	// 发送节奏
+	Pacing PacingConfig `json:"pacing"`
+	// 用户撤回提问时是否同时撤回机器人的回复，微信只能撤回2分钟内的消息
+	RecallReply bool `json:"recall_reply"`
+	// 内容审核
+	Moderation ModerationConfig `json:"moderation"`
+	// 隐私信息脱敏
+	Redaction RedactionConfig `json:"redaction"`
+	// 审计日志
+	Audit AuditConfig `json:"audit"`
+	// HTTP监听，提供监控指标
+	HTTP HTTPConfig `json:"http"`
+	// 数据目录，保存登录状态、会话快照、用量记录等文件
+	DataDir string `json:"data_dir"`
+	// 登录状态文件，为空时使用数据目录下的storage.json
+	StorageFile string `json:"storage_file"`
+	// 日志级别：debug、info、warning、error
+	LogLevel string `json:"log_level"`
+	// 日志格式：text、json
+	LogFormat string `json:"log_format"`
+	// 日志中是否记录提问和回复的内容，默认只记录长度
+	LogContent bool `json:"log_content"`
+
+	// 敏感配置引用的文件，修改后自动重新加载
+	secretFiles []string
+}
+
+// DigestSchedule 定时群聊摘要配置
+type DigestSchedule struct {
+	// 发送摘要的群名称
+	Groups []string `json:"groups"`
+	// 发送时间，格式为15:04
+	Time string `json:"time"`
+	// 每周发送的星期，如monday，为空时每天发送
+	Weekday string `json:"weekday"`
+	// 总结最近多少小时的聊天，为空时每天发送默认24小时，每周发送默认168小时
+	Hours int `json:"hours"`
+}
+
+// GroupTrigger 群聊触发规则，满足前缀、正则或概率任意一个条件即触发
+type GroupTrigger struct {
+	// 生效的群名称，为空时对所有群生效
+	Groups []string `json:"groups"`
+	// 唤醒词前缀，如"小助手,"，触发后去掉前缀作为问题
+	Prefix string `json:"prefix"`
+	// 正则表达式，匹配消息内容时触发
+	Pattern string `json:"pattern"`
+	// 随机插话的概率，0到1
+	Probability float64 `json:"probability"`
+	// 固定回复内容，为空时请求GPT回复
+	Reply string `json:"reply"`
+}
+
+// KnowledgeConfig 知识库配置
+type KnowledgeConfig struct {
+	// 知识库文档目录，支持Markdown和TXT文件，为空时不启用
+	Dir string `json:"dir"`
+	// 向量索引文件，相对路径位于数据目录下
+	IndexFile string `json:"index_file"`
+	// 是否使用embeddings接口获取向量，否则使用本地BM25检索
+	Embedding bool `json:"embedding"`
+	// embeddings模型
+	EmbeddingModel string `json:"embedding_model"`
+	// 文档切分的片段最大字符数
+	ChunkSize int `json:"chunk_size"`
+	// 每次提问引用的片段数量
+	TopK int `json:"top_k"`
+	// 启用知识库的群名称
+	Groups []string `json:"groups"`
+	// 私聊是否启用知识库
+	Private bool `json:"private"`
+}
+
+// WelcomeConfig 新成员入群欢迎配置
+type WelcomeConfig struct {
+	// 启用的群名称，为空时对所有群生效
+	Groups []string `json:"groups"`
+	// 欢迎语，支持模板变量：{{.Names}} 新成员名称，{{.Group}} 群名称，为空并且没有开启GPT时不发送
+	Message string `json:"message"`
+	// 群规，附加在欢迎语后面
+	Rules string `json:"rules"`
+	// 是否使用GPT生成个性化欢迎语，失败时使用配置的欢迎语
+	UseGPT bool `json:"use_gpt"`
+	// 合并欢迎的等待秒数，等待时间内入群的成员只发送一条欢迎消息
+	Delay int `json:"delay"`
+}
+
+// FriendRequestConfig 好友申请验证配置，开启自动通过好友时生效
+type FriendRequestConfig struct {
+	// 验证消息包含任意关键词时通过，关键词和正则都为空时全部通过
+	Keywords []string `json:"keywords"`
+	// 验证消息匹配任意正则时通过
+	Patterns []string `json:"patterns"`
+	// 通过后设置的备注名，支持模板变量：{{.NickName}} 昵称，{{.Content}} 验证消息
+	RemarkName string `json:"remark_name"`
+	// 通过后发送的招呼，支持的模板变量同备注名
+	Greeting string `json:"greeting"`
+	// 通过后邀请进入的群名称
+	InviteGroups []string `json:"invite_groups"`
+}
+
+// PacingConfig 发送节奏配置，时间单位均为毫秒
+type PacingConfig struct {
+	// 最短打字时间
+	MinDelay int `json:"min_delay"`
+	// 每个字的打字时间
+	PerCharDelay int `json:"per_char_delay"`
+	// 最长打字时间
+	MaxDelay int `json:"max_delay"`
+	// 打字时间的随机抖动比例，0到1
+	Jitter float64 `json:"jitter"`
+	// 两条消息之间的最短间隔
+	MinInterval int `json:"min_interval"`
+	// 每分钟最多发送的消息数
+	MaxPerMinute int `json:"max_per_minute"`
+	// 回复超过该字数时按段落分成多条发送，为0时不分段
+	SplitLength int `json:"split_length"`
+}
+
+// ModerationConfig 内容审核配置，同时审核用户提问和GPT回复
+type ModerationConfig struct {
+	// 敏感词文件，每行一个敏感词，修改后自动生效
+	WordsFile string `json:"words_file"`
+	// 是否调用审核接口
+	Endpoint bool `json:"endpoint"`
+	// 用户提问命中时的处理方式：refuse拒绝回复、mask替换为*、warn通知管理员，可以组合使用
+	InputActions []string `json:"input_actions"`
+	// GPT回复命中时的处理方式，同上
+	OutputActions []string `json:"output_actions"`
+	// 接收通知的管理员，好友的备注名或昵称
+	Admins []string `json:"admins"`
+	// 拒绝回复时的提示
+	RefuseText string `json:"refuse_text"`
+}
+
+// RedactionConfig 隐私信息脱敏配置，请求GPT之前把手机号、身份证号、银行卡号、邮箱替换为占位符
+type RedactionConfig struct {
+	// 是否启用脱敏
+	Enable bool `json:"enable"`
+	// 私聊回复中是否把占位符还原为原文
+	RestorePrivate bool `json:"restore_private"`
+	// 群聊回复中是否把占位符还原为原文，群里其他人也能看到，默认不还原
+	RestoreGroup bool `json:"restore_group"`
+}
+
+// StoragePath 登录状态文件路径
+func (c *Configuration) StoragePath() string {
+	if c.StorageFile != "" {
+		return c.StorageFile
+	}
+	return filepath.Join(c.DataDir, "storage.json")
+}
+
+// DataPath 数据目录下的文件路径
+func (c *Configuration) DataPath(name string) string {
+	return filepath.Join(c.DataDir, name)
+}
+
+// AuditConfig 审计日志配置，记录每一次提问和回复，按大小和日期切分文件
+type AuditConfig struct {
+	// 是否记录审计日志
+	Enable bool `json:"enable"`
+	// 审计日志目录，为空时使用数据目录下的audit目录
+	Dir string `json:"dir"`
+	// 单个文件的最大大小，单位MB，超过后切分，为0时不按大小切分
+	MaxSize int `json:"max_size"`
+	// 是否每天切分一个文件
+	Daily bool `json:"daily"`
+	// 切分后的文件保留天数，为0时不按时间删除
+	MaxAge int `json:"max_age"`
+	// 切分后的文件最多保留的个数，为0时不按个数删除
+	MaxBackups int `json:"max_backups"`
+}
+
+// HTTPConfig HTTP监听配置，监听地址修改后需要重启才能生效
+type HTTPConfig struct {
+	// 监听地址，如127.0.0.1:9090，为空时不监听
+	Listen string `json:"listen"`
+	// 检查GPT服务能否访问的间隔，为0时不检查，/readyz不再要求GPT服务可以访问
+	LLMCheckInterval Duration `json:"llm_check_interval"`
+}
+
+// KnowledgeIndexPath 知识库向量索引文件路径，相对路径位于数据目录下，多个实例使用不同的数据目录时互不影响
+func (c *Configuration) KnowledgeIndexPath() string {
+	name := c.Knowledge.IndexFile
+	if name == "" {
+		name = "knowledge.index.json"
+	}
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return c.DataPath(name)
+}
+
+// AuditDir 审计日志目录
+func (c *Configuration) AuditDir() string {
+	if c.Audit.Dir != "" {
+		return c.Audit.Dir
+	}
+	return c.DataPath("audit")
 }
 
-var config *Configuration
+// file 指定的配置文件路径，为空时查找默认的配置文件
+var file string
+
+// current 当前生效的配置，热更新时整体替换，已经取到的配置不会被修改
+var current atomic.Value
 var once sync.Once
 
-// LoadConfig 加载配置
+// LoadConfig 加载配置，配置热更新后返回新的配置，配置的问题在启动时由Check统一报告
 func LoadConfig() *Configuration {
 	once.Do(func() {
-		// 给配置赋默认值
-		config = &Configuration{
-			AutoPass:          false,
-			SessionTimeout:    60,
-			MaxTokens:         512,
-			Model:             "text-davinci-003",
-			Temperature:       0.9,
-			SessionClearToken: "下个问题",
-		}
-
-		// 判断配置文件是否存在，存在直接JSON读取
-		_, err := os.Stat("config.json")
-		if err == nil {
-			f, err := os.Open("config.json")
-			if err != nil {
-				logger.Danger(fmt.Sprintf("open config error: %v", err))
-				return
-			}
-			defer f.Close()
-			encoder := json.NewDecoder(f)
-			err = encoder.Decode(config)
-			if err != nil {
-				logger.Danger(fmt.Sprintf("decode config error: %v", err))
-				return
-			}
-		}
-		// 有环境变量使用环境变量
-		ApiKey := os.Getenv("APIKEY")
-		AutoPass := os.Getenv("AUTO_PASS")
-		SessionTimeout := os.Getenv("SESSION_TIMEOUT")
-		Model := os.Getenv("MODEL")
-		MaxTokens := os.Getenv("MAX_TOKENS")
-		Temperature := os.Getenv("TEMPREATURE")
-		ReplyPrefix := os.Getenv("REPLY_PREFIX")
-		SessionClearToken := os.Getenv("SESSION_CLEAR_TOKEN")
-		if ApiKey != "" {
-			config.ApiKey = ApiKey
-		}
-		if AutoPass == "true" {
-			config.AutoPass = true
-		}
-		if SessionTimeout != "" {
-			duration, err := time.ParseDuration(SessionTimeout)
-			if err != nil {
-				logger.Danger(fmt.Sprintf("config session timeout error: %v, get is %v", err, SessionTimeout))
-				return
-			}
-			config.SessionTimeout = duration
-		}
-		if Model != "" {
-			config.Model = Model
-		}
-		if MaxTokens != "" {
-			max, err := strconv.Atoi(MaxTokens)
-			if err != nil {
-				logger.Danger(fmt.Sprintf("config max tokens error: %v ,get is %v", err, MaxTokens))
-				return
-			}
-			config.MaxTokens = uint(max)
-		}
-		if Temperature != "" {
-			temp, err := strconv.ParseFloat(Temperature, 64)
-			if err != nil {
-				logger.Danger(fmt.Sprintf("config temperature error: %v, get is %v", err, Temperature))
-				return
-			}
-			config.Temperature = temp
-		}
-		if ReplyPrefix != "" {
-			config.ReplyPrefix = ReplyPrefix
-		}
-		if SessionClearToken != "" {
-			config.SessionClearToken = SessionClearToken
-		}
-
+		config, _ := load()
+		current.Store(config)
 	})
-	if config.ApiKey == "" {
-		logger.Danger("config error: api key required")
+	return current.Load().(*Configuration)
+}
+
+// Check 重新读取配置文件和环境变量，返回发现的所有问题，不影响当前生效的配置
+func Check() []string {
+	_, problems := load()
+	return problems
+}
+
+// defaultConfig 配置默认值
+func defaultConfig() *Configuration {
+	return &Configuration{
+		AutoPass:          false,
+		SessionTimeout:    Duration(60 * time.Second),
+		MaxTokens:         512,
+		Model:             "text-davinci-003",
+		Temperature:       0.9,
+		SessionClearToken: "下个问题",
+		GroupBufferSize:   500,
+		WorkStartHour:     9,
+		WorkEndHour:       21,
+		FaqFile:           "faq.json",
+		Knowledge: KnowledgeConfig{
+			IndexFile:      "knowledge.index.json",
+			EmbeddingModel: "text-embedding-ada-002",
+			ChunkSize:      500,
+			TopK:           3,
+		},
+		Welcome: WelcomeConfig{
+			Delay: 10,
+		},
+		DedupTTL:      24 * 3600,
+		StaleWindow:   60,
+		BacklogWindow: 3600,
+		BacklogPrefix: "抱歉，刚才机器人掉线了，现在回复您：",
+		Workers:       4,
+		QueueDepth:    10,
+		QueueSize:     200,
+		Moderation: ModerationConfig{
+			WordsFile:     "sensitive_words.txt",
+			InputActions:  []string{"refuse"},
+			OutputActions: []string{"refuse"},
+			RefuseText:    "抱歉，这个问题我无法回答[捂脸]",
+		},
+		Audit: AuditConfig{
+			Enable:  true,
+			MaxSize: 100,
+			Daily:   true,
+			MaxAge:  90,
+		},
+		HTTP: HTTPConfig{
+			LLMCheckInterval: Duration(time.Minute),
+		},
+		DataDir:   ".",
+		LogLevel:  "info",
+		LogFormat: "text",
+		Redaction: RedactionConfig{
+			Enable:         true,
+			RestorePrivate: true,
+		},
+		Pacing: PacingConfig{
+			MinDelay:     1000,
+			PerCharDelay: 50,
+			MaxDelay:     8000,
+			Jitter:       0.3,
+			MinInterval:  1500,
+			MaxPerMinute: 20,
+			SplitLength:  1000,
+		},
 	}
+}
+
+// load 依次使用默认值、配置文件、配置片段、环境变量、命令行参数生成配置并校验，返回配置以及发现的所有问题
+func load() (*Configuration, []string) {
+	config := defaultConfig()
+	problems := make([]string, 0)
 
-	return config
+	// 1.配置文件，支持JSON、YAML、TOML
+	if path := configFile(); path != "" {
+		problems = append(problems, loadFile(config, path)...)
+	}
+	// 2.config.d目录中的配置片段，按文件名顺序覆盖
+	for _, path := range fragmentFiles() {
+		problems = append(problems, loadFile(config, path)...)
+	}
+	// 3.环境变量
+	problems = append(problems, loadEnv(config)...)
+	// 4.命令行参数
+	problems = append(problems, applyOverrides(config, overrides)...)
+	// 5.解析api_key等敏感配置中的file:、env:引用
+	problems = append(problems, resolveSecrets(config)...)
+	return config, append(problems, validate(config)...)
 }
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration 时间长度，配置文件和环境变量中都可以写秒数（如60）或者带单位的时间（如"10m"、"1h30m"）
+type Duration time.Duration
+
+// ParseDuration 解析时间长度，纯数字按秒计算
+func ParseDuration(text string) (Duration, error) {
+	text = strings.TrimSpace(text)
+	if seconds, err := strconv.ParseFloat(text, 64); err == nil {
+		return Duration(seconds * float64(time.Second)), nil
+	}
+	duration, err := time.ParseDuration(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, use seconds like 60 or a duration like \"10m\"", text)
+	}
+	return Duration(duration), nil
+}
+
+// UnmarshalJSON 支持数字和字符串两种写法
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		text = string(data)
+	}
+	duration, err := ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = duration
+	return nil
+}
+
+// MarshalJSON 输出为带单位的时间
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
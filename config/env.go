@@ -0,0 +1,202 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+)
+
+// envPrefix 环境变量前缀，配置项路径转为大写并用下划线连接，如WECHATBOT_PACING_MIN_DELAY
+const envPrefix = "WECHATBOT_"
+
+// envAliases 兼容旧版本的环境变量，优先级低于WECHATBOT_开头的环境变量
+var envAliases = [][2]string{
+	{"APIKEY", "API_KEY"},
+	{"AUTO_PASS", "AUTO_PASS"},
+	{"SESSION_TIMEOUT", "SESSION_TIMEOUT"},
+	{"MODEL", "MODEL"},
+	{"MAX_TOKENS", "MAX_TOKENS"},
+	{"TEMPREATURE", "TEMPERATURE"},
+	{"REPLY_PREFIX", "REPLY_PREFIX"},
+	{"SESSION_CLEAR_TOKEN", "SESSION_CLEAR_TOKEN"},
+}
+
+// serviceEnvPattern Kubernetes为名为wechatbot的Service自动注入的环境变量，如WECHATBOT_SERVICE_HOST、WECHATBOT_PORT_9090_TCP，不是配置项
+var serviceEnvPattern = regexp.MustCompile(`^(SERVICE_HOST|SERVICE_PORT(_.*)?|PORT(_[0-9]+_(TCP|UDP|SCTP)(_.*)?)?)$`)
+
+// unknownConfigError 环境变量没有对应的配置项
+type unknownConfigError string
+
+func (e unknownConfigError) Error() string {
+	return fmt.Sprintf("unknown config %q", string(e))
+}
+
+// loadEnv 有环境变量使用环境变量，返回发现的问题
+// 每个配置项都可以通过WECHATBOT_开头的环境变量设置：
+// 列表可以写JSON数组或者逗号分隔，如WECHATBOT_SHARED_SESSION_GROUPS=群1,群2；
+// 列表中的元素通过下标设置，如WECHATBOT_GROUP_TRIGGERS_0_PREFIX=小助手；
+// 没有对应配置项的WECHATBOT_开头的环境变量只打印警告，其他程序或者Kubernetes也可能设置这个前缀的环境变量
+func loadEnv(config *Configuration) []string {
+	problems := make([]string, 0)
+	value := reflect.ValueOf(config).Elem()
+
+	// 1.兼容旧版本的环境变量
+	for _, alias := range envAliases {
+		text := os.Getenv(alias[0])
+		if text == "" {
+			continue
+		}
+		if err := setEnv(value, alias[1], text); err != nil {
+			problems = append(problems, fmt.Sprintf("env %s error: %v", alias[0], err))
+		}
+	}
+
+	// 2.WECHATBOT_开头的环境变量，按名称排序，名称中的下标按数字大小排序，保证列表元素按下标顺序追加
+	names := make([]string, 0)
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, envPrefix) {
+			names = append(names, strings.SplitN(env, "=", 2)[0])
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return envSortKey(names[i]) < envSortKey(names[j])
+	})
+	for _, name := range names {
+		text := os.Getenv(name)
+		path := strings.TrimPrefix(name, envPrefix)
+		if text == "" || serviceEnvPattern.MatchString(path) {
+			continue
+		}
+		err := setEnv(value, path, text)
+		var unknown unknownConfigError
+		if errors.As(err, &unknown) {
+			logger.Warning(fmt.Sprintf("env %s ignored: %v", name, err))
+			continue
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("env %s error: %v", name, err))
+		}
+	}
+	return problems
+}
+
+// setEnv 按大写下划线路径找到配置项并设置，path如PACING_MIN_DELAY、GROUP_TRIGGERS_0_PREFIX
+func setEnv(value reflect.Value, path, text string) error {
+	switch value.Kind() {
+	case reflect.Struct:
+		// 配置项名称本身包含下划线，取能匹配上的最长名称
+		var field reflect.Value
+		rest, matched := "", ""
+		for i := 0; i < value.NumField(); i++ {
+			name := strings.ToUpper(jsonName(value.Type().Field(i)))
+			if value.Type().Field(i).PkgPath != "" || len(name) <= len(matched) {
+				continue
+			}
+			if path == name || strings.HasPrefix(path, name+"_") {
+				field, matched = value.Field(i), name
+				rest = strings.TrimPrefix(strings.TrimPrefix(path, name), "_")
+			}
+		}
+		if matched == "" {
+			return unknownConfigError(path)
+		}
+		if rest == "" {
+			return setText(field, text)
+		}
+		return setEnv(field, rest, text)
+	case reflect.Slice:
+		// 列表元素的下标，等于列表长度时追加
+		parts := strings.SplitN(path, "_", 2)
+		index, err := strconv.Atoi(parts[0])
+		if err != nil || index < 0 || index > value.Len() {
+			return fmt.Errorf("invalid index %q, list has %d items", parts[0], value.Len())
+		}
+		if index == value.Len() {
+			value.Set(reflect.Append(value, reflect.Zero(value.Type().Elem())))
+		}
+		if len(parts) == 1 {
+			return setText(value.Index(index), text)
+		}
+		return setEnv(value.Index(index), parts[1], text)
+	default:
+		return unknownConfigError(path)
+	}
+}
+
+// setText 把环境变量的文本转换为配置项的类型
+func setText(value reflect.Value, text string) error {
+	// 时间长度支持秒数和"10m"两种写法
+	if value.Type() == reflect.TypeOf(Duration(0)) {
+		duration, err := ParseDuration(text)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(duration))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("invalid bool %q", text)
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", text)
+		}
+		value.SetInt(i)
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(text, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", text)
+		}
+		value.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", text)
+		}
+		value.SetFloat(f)
+	case reflect.Slice:
+		// 字符串列表可以用逗号分隔，其他列表使用JSON
+		if value.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(text), "[") {
+			items := strings.Split(text, ",")
+			list := reflect.MakeSlice(value.Type(), 0, len(items))
+			for _, item := range items {
+				if item = strings.TrimSpace(item); item != "" {
+					list = reflect.Append(list, reflect.ValueOf(item))
+				}
+			}
+			value.Set(list)
+			return nil
+		}
+		return json.Unmarshal([]byte(text), value.Addr().Interface())
+	default:
+		return json.Unmarshal([]byte(text), value.Addr().Interface())
+	}
+	return nil
+}
+
+// envSortKey 把名称中的数字补齐为固定长度，GROUP_TRIGGERS_2排在GROUP_TRIGGERS_10前面
+func envSortKey(name string) string {
+	parts := strings.Split(name, "_")
+	for i, part := range parts {
+		if _, err := strconv.Atoi(part); err == nil {
+			parts[i] = fmt.Sprintf("%08s", part)
+		}
+	}
+	return strings.Join(parts, "_")
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setenv 设置环境变量，测试结束后恢复，清空其他WECHATBOT_开头和兼容旧版本的环境变量，避免受运行环境影响
+func setenv(t *testing.T, values map[string]string) {
+	t.Helper()
+	saved := os.Environ()
+	t.Cleanup(func() {
+		os.Clearenv()
+		for _, env := range saved {
+			parts := strings.SplitN(env, "=", 2)
+			_ = os.Setenv(parts[0], parts[1])
+		}
+	})
+	for _, env := range saved {
+		name := strings.SplitN(env, "=", 2)[0]
+		if strings.HasPrefix(name, envPrefix) {
+			_ = os.Unsetenv(name)
+		}
+	}
+	for _, alias := range envAliases {
+		_ = os.Unsetenv(alias[0])
+	}
+	for name, value := range values {
+		_ = os.Setenv(name, value)
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(config *Configuration) bool
+		// 期望发现的问题数
+		problems int
+	}{
+		{
+			name: "top level",
+			env:  map[string]string{"WECHATBOT_MAX_TOKENS": "1024", "WECHATBOT_SESSION_TIMEOUT": "10m"},
+			check: func(c *Configuration) bool {
+				return c.MaxTokens == 1024 && c.SessionTimeout == Duration(10*time.Minute)
+			},
+		},
+		{
+			name:  "nested field",
+			env:   map[string]string{"WECHATBOT_PACING_MIN_DELAY": "300", "WECHATBOT_REDACTION_RESTORE_GROUP": "true"},
+			check: func(c *Configuration) bool { return c.Pacing.MinDelay == 300 && c.Redaction.RestoreGroup },
+		},
+		{
+			name:  "field name with underscore",
+			env:   map[string]string{"WECHATBOT_HTTP_LLM_CHECK_INTERVAL": "30"},
+			check: func(c *Configuration) bool { return c.HTTP.LLMCheckInterval == Duration(30*time.Second) },
+		},
+		{
+			name: "comma separated list",
+			env:  map[string]string{"WECHATBOT_SHARED_SESSION_GROUPS": "群1, 群2,,群3"},
+			check: func(c *Configuration) bool {
+				return reflect.DeepEqual(c.SharedSessionGroups, []string{"群1", "群2", "群3"})
+			},
+		},
+		{
+			name: "json list",
+			env:  map[string]string{"WECHATBOT_SHARED_SESSION_GROUPS": `["a,b","c"]`},
+			check: func(c *Configuration) bool {
+				return reflect.DeepEqual(c.SharedSessionGroups, []string{"a,b", "c"})
+			},
+		},
+		{
+			name: "list index append in numeric order",
+			env: map[string]string{
+				"WECHATBOT_GROUP_TRIGGERS_0_PREFIX":   "小助手",
+				"WECHATBOT_GROUP_TRIGGERS_1_PATTERN":  "^帮我",
+				"WECHATBOT_GROUP_TRIGGERS_1_GROUPS_0": "群1",
+			},
+			check: func(c *Configuration) bool {
+				return len(c.GroupTriggers) == 2 && c.GroupTriggers[0].Prefix == "小助手" &&
+					c.GroupTriggers[1].Pattern == "^帮我" && reflect.DeepEqual(c.GroupTriggers[1].Groups, []string{"群1"})
+			},
+		},
+		{
+			name: "list index beyond length",
+			env:  map[string]string{"WECHATBOT_GROUP_TRIGGERS_2_PREFIX": "x"},
+			check: func(c *Configuration) bool {
+				return len(c.GroupTriggers) == 0
+			},
+			problems: 1,
+		},
+		{
+			name: "legacy alias",
+			env:  map[string]string{"APIKEY": "sk-legacy", "TEMPREATURE": "0.5", "AUTO_PASS": "true"},
+			check: func(c *Configuration) bool {
+				return c.ApiKey == "sk-legacy" && c.Temperature == 0.5 && c.AutoPass
+			},
+		},
+		{
+			name:  "prefixed env overrides legacy alias",
+			env:   map[string]string{"APIKEY": "sk-legacy", "WECHATBOT_API_KEY": "sk-new"},
+			check: func(c *Configuration) bool { return c.ApiKey == "sk-new" },
+		},
+		{
+			name: "kubernetes service env and unknown env are not problems",
+			env: map[string]string{
+				"WECHATBOT_SERVICE_HOST":       "10.0.0.1",
+				"WECHATBOT_SERVICE_PORT":       "9090",
+				"WECHATBOT_PORT":               "tcp://10.0.0.1:9090",
+				"WECHATBOT_PORT_9090_TCP_ADDR": "10.0.0.1",
+				"WECHATBOT_SOMETHING_ELSE":     "x",
+				"WECHATBOT_MODEL":              "gpt-4",
+			},
+			check: func(c *Configuration) bool { return c.Model == "gpt-4" },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setenv(t, test.env)
+			config := defaultConfig()
+			problems := loadEnv(config)
+			if len(problems) != test.problems {
+				t.Fatalf("expected %d problems, got %v", test.problems, problems)
+			}
+			if !test.check(config) {
+				t.Errorf("unexpected config: %+v", config)
+			}
+		})
+	}
+}
+
+func TestLoadEnvInvalidValue(t *testing.T) {
+	setenv(t, map[string]string{"WECHATBOT_MAX_TOKENS": "many", "WECHATBOT_AUTO_PASS": "maybe"})
+	if problems := loadEnv(defaultConfig()); len(problems) != 2 {
+		t.Errorf("expected 2 problems, got %v", problems)
+	}
+}
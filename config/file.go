@@ -0,0 +1,192 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultFiles 没有指定配置文件时依次查找的文件
+var defaultFiles = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// fragmentDir 配置片段目录，位于配置文件所在目录下，按文件名顺序覆盖配置文件
+const fragmentDir = "config.d"
+
+// overrides 命令行传入的配置，格式为key.path=value，优先级最高
+var overrides []string
+
+// SetFile 指定配置文件，需要在第一次LoadConfig之前调用
+func SetFile(path string) {
+	file = path
+}
+
+// SetOverrides 设置命令行传入的配置，如max_tokens=1024、pacing.jitter=0.5，需要在第一次LoadConfig之前调用
+func SetOverrides(values []string) {
+	overrides = values
+}
+
+// configFile 实际使用的配置文件，没有指定时查找默认的文件，都不存在时返回空字符串
+func configFile() string {
+	if file != "" {
+		return file
+	}
+	for _, name := range defaultFiles {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// fragmentFiles 配置片段文件，按文件名排序
+func fragmentFiles() []string {
+	dir := fragmentDir
+	if path := configFile(); path != "" {
+		dir = filepath.Join(filepath.Dir(path), fragmentDir)
+	}
+	files := make([]string, 0)
+	for _, pattern := range []string{"*.yaml", "*.yml", "*.json", "*.toml"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// loadFile 读取配置文件覆盖到config，支持JSON、YAML、TOML，返回发现的问题
+func loadFile(config *Configuration, path string) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return []string{fmt.Sprintf("read config error: %v", err)}
+	}
+	data, err = toJSON(path, data)
+	if err != nil {
+		return []string{fmt.Sprintf("decode %s error: %v", path, err)}
+	}
+	if err = json.Unmarshal(data, config); err != nil {
+		return []string{fmt.Sprintf("decode %s error: %v", path, err)}
+	}
+
+	problems := unknownKeys(data)
+	for i, problem := range problems {
+		problems[i] = path + ": " + problem
+	}
+	return problems
+}
+
+// toJSON 把YAML、TOML转换为JSON，统一使用json标签解析，配置项名称在各种格式中保持一致
+func toJSON(path string, data []byte) ([]byte, error) {
+	var value interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		object := make(map[string]interface{})
+		if err := toml.Unmarshal(data, &object); err != nil {
+			return nil, err
+		}
+		value = object
+	default:
+		return data, nil
+	}
+	// 空文件
+	if value == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(value)
+}
+
+// applyOverrides 把命令行传入的配置覆盖到config，返回发现的问题
+func applyOverrides(config *Configuration, values []string) []string {
+	problems := make([]string, 0)
+	for _, value := range values {
+		// 只打印配置项名称，避免把api_key等敏感配置打印出来
+		if err := setValue(config, value); err != nil {
+			problems = append(problems, fmt.Sprintf("--set %s error: %v", strings.SplitN(value, "=", 2)[0], err))
+		}
+	}
+	return problems
+}
+
+// setValue 按key.path=value设置配置，value可以是JSON，如数字、true、["a","b"]，否则作为字符串
+func setValue(config *Configuration, assignment string) error {
+	index := strings.Index(assignment, "=")
+	if index <= 0 {
+		return fmt.Errorf("must be like key=value")
+	}
+	keys := strings.Split(strings.TrimSpace(assignment[:index]), ".")
+	text := assignment[index+1:]
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		value = text
+	}
+	data, err := json.Marshal(nested(keys, value))
+	if err != nil {
+		return err
+	}
+	if problems := unknownKeys(data); len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	if err = json.Unmarshal(data, config); err == nil {
+		return nil
+	}
+
+	// 字符串类型的配置写成了数字等JSON，按原始字符串再设置一次
+	if _, ok := err.(*json.UnmarshalTypeError); ok {
+		data, _ = json.Marshal(nested(keys, text))
+		return json.Unmarshal(data, config)
+	}
+	return err
+}
+
+// nested 按key路径生成嵌套的对象
+func nested(keys []string, value interface{}) interface{} {
+	for i := len(keys) - 1; i >= 0; i-- {
+		value = map[string]interface{}{keys[i]: value}
+	}
+	return value
+}
+
+// Effective 当前生效的配置，格式化为JSON，api_key等敏感配置只显示最后几位
+func Effective() ([]byte, error) {
+	config := *LoadConfig()
+	maskSecrets(reflect.ValueOf(&config).Elem())
+	return json.MarshalIndent(config, "", "  ")
+}
+
+// maskSecrets 遍历配置，把标记了secret的字段替换为掩码
+func maskSecrets(value reflect.Value) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		switch {
+		case value.Type().Field(i).PkgPath != "":
+			continue
+		case value.Type().Field(i).Tag.Get("secret") == "true" && field.Kind() == reflect.String:
+			field.SetString(Mask(field.String()))
+		case field.Kind() == reflect.Struct:
+			maskSecrets(field)
+		}
+	}
+}
+
+// Mask 敏感信息掩码，只保留最后4位，较短时全部隐藏
+func Mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) <= 8 {
+		return "****"
+	}
+	return "****" + secret[len(secret)-4:]
+}
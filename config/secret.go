@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// 敏感配置的引用前缀，配置中不需要写明文
+const (
+	// secretFilePrefix 从文件读取，如file:/run/secrets/openai
+	secretFilePrefix = "file:"
+	// secretEnvPrefix 从环境变量读取，如env:OPENAI_API_KEY
+	secretEnvPrefix = "env:"
+)
+
+// resolveSecrets 解析标记了secret的配置项中的引用，每次加载和重新加载时读取，返回发现的问题
+// 问题中只包含配置项名称和引用，不包含读取到的内容
+func resolveSecrets(config *Configuration) []string {
+	config.secretFiles = nil
+	return resolveValue(config, reflect.ValueOf(config).Elem(), "")
+}
+
+// resolveValue 递归遍历配置，path为当前位置
+func resolveValue(config *Configuration, value reflect.Value, path string) []string {
+	problems := make([]string, 0)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		structField := value.Type().Field(i)
+		if structField.PkgPath != "" {
+			continue
+		}
+		name := path + jsonName(structField)
+		switch {
+		case field.Kind() == reflect.Struct:
+			problems = append(problems, resolveValue(config, field, name+".")...)
+		case field.Kind() == reflect.String && structField.Tag.Get("secret") == "true":
+			secret, err := resolveSecret(config, field.String())
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s error: %v", name, err))
+				continue
+			}
+			field.SetString(secret)
+		}
+	}
+	return problems
+}
+
+// resolveSecret 解析一个引用，不是引用时原样返回
+func resolveSecret(config *Configuration, text string) (string, error) {
+	switch {
+	case strings.HasPrefix(text, secretFilePrefix):
+		path := strings.TrimPrefix(text, secretFilePrefix)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("read secret file error: %v", err)
+		}
+		// 修改密钥文件后自动重新加载
+		config.secretFiles = append(config.secretFiles, path)
+		secret := strings.TrimSpace(string(data))
+		if secret == "" {
+			return "", fmt.Errorf("secret file %s is empty", path)
+		}
+		return secret, nil
+	case strings.HasPrefix(text, secretEnvPrefix):
+		name := strings.TrimPrefix(text, secretEnvPrefix)
+		secret := strings.TrimSpace(os.Getenv(name))
+		if secret == "" {
+			return "", fmt.Errorf("secret env %s is empty", name)
+		}
+		return secret, nil
+	default:
+		return text, nil
+	}
+}
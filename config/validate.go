@@ -0,0 +1,223 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/rule"
+)
+
+// modelTokenLimits 常用模型的最大token数，提问和回复共用，max_tokens必须小于该值
+var modelTokenLimits = map[string]uint{
+	"text-davinci-003":   4097,
+	"text-davinci-002":   4097,
+	"gpt-3.5-turbo":      4096,
+	"gpt-3.5-turbo-0301": 4096,
+	"gpt-3.5-turbo-16k":  16384,
+	"gpt-4":              8192,
+	"gpt-4-0314":         8192,
+	"gpt-4-32k":          32768,
+}
+
+// moderationActions 内容审核支持的处理方式
+var moderationActions = []string{"refuse", "mask", "warn"}
+
+// logLevels 支持的日志级别
+var logLevels = []string{"debug", "info", "warning", "error"}
+
+// logFormats 支持的日志格式
+var logFormats = []string{"text", "json"}
+
+// weekdays 定时摘要支持的星期写法
+var weekdays = []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+// validate 校验配置的取值，返回发现的所有问题
+func validate(config *Configuration) []string {
+	problems := make([]string, 0)
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	// 1.GPT相关
+	check(config.ApiKey != "", "api_key required")
+	check(config.Model != "", "model required")
+	check(config.Temperature >= 0 && config.Temperature <= 2, "temperature must be between 0 and 2, get is %v", config.Temperature)
+	check(config.MaxTokens > 0, "max_tokens must be greater than 0")
+	if limit, ok := modelTokenLimits[config.Model]; ok {
+		check(config.MaxTokens < limit, "max_tokens %d exceeds the %d token limit of model %s, leave room for the question", config.MaxTokens, limit, config.Model)
+	}
+	check(config.SessionTimeout > 0, "session_timeout must be greater than 0")
+
+	// 2.时间和数量
+	check(config.WorkStartHour >= 0 && config.WorkStartHour <= 24, "work_start_hour must be between 0 and 24, get is %d", config.WorkStartHour)
+	check(config.WorkEndHour >= 0 && config.WorkEndHour <= 24, "work_end_hour must be between 0 and 24, get is %d", config.WorkEndHour)
+	check(config.WorkStartHour < config.WorkEndHour, "work_start_hour %d must be earlier than work_end_hour %d", config.WorkStartHour, config.WorkEndHour)
+	check(config.GroupBufferSize >= 0, "group_buffer_size must not be negative")
+	check(config.DedupTTL >= 0, "dedup_ttl must not be negative, use 0 to disable dedup")
+	check(config.StaleWindow >= 0, "stale_window must not be negative")
+	check(!config.AnswerBacklog || config.BacklogWindow >= config.StaleWindow, "backlog_window %d must not be less than stale_window %d", config.BacklogWindow, config.StaleWindow)
+	check(config.Workers > 0, "workers must be greater than 0")
+	check(config.QueueDepth >= 0, "queue_depth must not be negative")
+	check(config.QueueSize >= 0, "queue_size must not be negative")
+
+	// 3.发送节奏
+	pacing := config.Pacing
+	check(pacing.MinDelay >= 0 && pacing.PerCharDelay >= 0 && pacing.MaxDelay >= 0 && pacing.MinInterval >= 0,
+		"pacing delays must not be negative")
+	check(pacing.MinDelay <= pacing.MaxDelay, "pacing.min_delay %d must not be greater than pacing.max_delay %d", pacing.MinDelay, pacing.MaxDelay)
+	check(pacing.Jitter >= 0 && pacing.Jitter <= 1, "pacing.jitter must be between 0 and 1, get is %v", pacing.Jitter)
+	check(pacing.MaxPerMinute >= 0, "pacing.max_per_minute must not be negative")
+	check(pacing.SplitLength >= 0, "pacing.split_length must not be negative")
+
+	// 4.群功能
+	for i, schedule := range config.DigestSchedules {
+		_, err := time.Parse("15:04", schedule.Time)
+		check(err == nil, "digest_schedules[%d].time %q must be like 15:04", i, schedule.Time)
+		check(schedule.Weekday == "" || validWeekday(schedule.Weekday), "digest_schedules[%d].weekday %q is invalid", i, schedule.Weekday)
+		check(len(schedule.Groups) > 0, "digest_schedules[%d].groups required", i)
+	}
+	for i, trigger := range config.GroupTriggers {
+		if trigger.Pattern != "" {
+			_, err := regexp.Compile(trigger.Pattern)
+			check(err == nil, "group_triggers[%d].pattern error: %v", i, err)
+		}
+		check(trigger.Probability >= 0 && trigger.Probability <= 1, "group_triggers[%d].probability must be between 0 and 1, get is %v", i, trigger.Probability)
+	}
+	for i, pattern := range config.FriendRequest.Patterns {
+		_, err := regexp.Compile(pattern)
+		check(err == nil, "friend_request.patterns[%d] error: %v", i, err)
+	}
+	check(config.Welcome.Delay >= 0, "welcome.delay must not be negative")
+	if config.Knowledge.Dir != "" {
+		check(config.Knowledge.ChunkSize > 0, "knowledge.chunk_size must be greater than 0")
+		check(config.Knowledge.TopK > 0, "knowledge.top_k must be greater than 0")
+	}
+
+	// 5.内容审核
+	for _, action := range config.Moderation.InputActions {
+		check(rule.Grule.InSlice(action, moderationActions), "moderation.input_actions %q is invalid, use one of %s", action, strings.Join(moderationActions, ", "))
+	}
+	for _, action := range config.Moderation.OutputActions {
+		check(rule.Grule.InSlice(action, moderationActions), "moderation.output_actions %q is invalid, use one of %s", action, strings.Join(moderationActions, ", "))
+	}
+
+	// 6.审计日志
+	check(config.Audit.MaxSize >= 0, "audit.max_size must not be negative")
+	check(config.Audit.MaxAge >= 0, "audit.max_age must not be negative")
+	check(config.Audit.MaxBackups >= 0, "audit.max_backups must not be negative")
+
+	// 7.运行环境
+	if config.HTTP.Listen != "" {
+		_, port, err := net.SplitHostPort(config.HTTP.Listen)
+		check(err == nil && port != "", "http.listen %q is invalid, use like 127.0.0.1:9090", config.HTTP.Listen)
+	}
+	check(config.HTTP.LLMCheckInterval >= 0, "http.llm_check_interval must not be negative")
+	check(config.DataDir != "", "data_dir required")
+	check(rule.Grule.InSlice(strings.ToLower(config.LogLevel), logLevels), "log_level %q is invalid, use one of %s", config.LogLevel, strings.Join(logLevels, ", "))
+	check(rule.Grule.InSlice(strings.ToLower(config.LogFormat), logFormats), "log_format %q is invalid, use one of %s", config.LogFormat, strings.Join(logFormats, ", "))
+	return problems
+}
+
+// unknownKeys 检查配置文件中没有对应配置项的key，通常是拼写错误
+func unknownKeys(data []byte) []string {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil
+	}
+	return unknownFields(value, reflect.TypeOf(Configuration{}), "")
+}
+
+// unknownFields 按结构体的json标签递归检查未知的key，path为当前位置
+func unknownFields(value interface{}, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	problems := make([]string, 0)
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return problems
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := object[key]
+			field, ok := fieldByKey(t, key)
+			if !ok {
+				problem := fmt.Sprintf("unknown key %q", path+key)
+				if suggestion := suggestKey(t, key); suggestion != "" {
+					problem += fmt.Sprintf(", did you mean %q?", path+suggestion)
+				}
+				problems = append(problems, problem)
+				continue
+			}
+			problems = append(problems, unknownFields(child, field.Type, path+key+".")...)
+		}
+	case reflect.Slice:
+		list, ok := value.([]interface{})
+		if !ok {
+			return problems
+		}
+		for i, child := range list {
+			problems = append(problems, unknownFields(child, t.Elem(), fmt.Sprintf("%s[%d].", strings.TrimSuffix(path, "."), i))...)
+		}
+	}
+	return problems
+}
+
+// fieldByKey 查找key对应的字段，和encoding/json一样不区分大小写
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath == "" && strings.EqualFold(jsonName(field), key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// suggestKey 忽略下划线和大小写后相同的配置项，如maxtokens对应max_tokens
+func suggestKey(t reflect.Type, key string) string {
+	normalize := func(text string) string {
+		return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(text))
+	}
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonName(t.Field(i))
+		if t.Field(i).PkgPath == "" && normalize(name) == normalize(key) {
+			return name
+		}
+	}
+	return ""
+}
+
+// jsonName 字段在配置文件中的名称
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// validWeekday 星期是否为英文全称或缩写
+func validWeekday(text string) bool {
+	text = strings.ToLower(text)
+	for _, day := range weekdays {
+		if text == day || text == day[:3] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/pkg/filewatch"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+)
+
+// watchInterval 检查配置文件是否修改的间隔
+const watchInterval = 3 * time.Second
+
+// Subscriber 配置更新后的回调，old为更新前的配置，config为更新后的配置
+type Subscriber func(old, config *Configuration)
+
+var (
+	subscribers []Subscriber
+	// reloadLock 保证同一时间只有一次重新加载，回调按顺序执行
+	reloadLock sync.Mutex
+)
+
+// Subscribe 订阅配置更新，缓存、定时任务等启动时读取配置的模块通过回调应用新的配置
+func Subscribe(subscriber Subscriber) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	subscribers = append(subscribers, subscriber)
+}
+
+// Reload 重新加载并校验配置，校验通过后替换当前配置并通知订阅者，失败时继续使用原来的配置
+func Reload() error {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	config, problems := load()
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	old := LoadConfig()
+	current.Store(config)
+	for _, subscriber := range subscribers {
+		subscriber(old, config)
+	}
+	return nil
+}
+
+// Watch 监听配置文件修改以及SIGHUP信号，自动重新加载配置，不需要重新扫码登录
+func Watch() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		fingerprint := fileFingerprint()
+		for {
+			select {
+			case <-signals:
+				logger.Info("received SIGHUP, reload config")
+			case <-ticker.C:
+				latest := fileFingerprint()
+				if latest == fingerprint {
+					continue
+				}
+				fingerprint = latest
+				logger.Info("config file changed, reload config")
+			}
+			if err := Reload(); err != nil {
+				logger.Warning(fmt.Sprintf("reload config error, keep the previous config: %v", err))
+				continue
+			}
+			logger.Info("config reloaded")
+		}
+	}()
+}
+
+// fileFingerprint 配置文件、配置片段以及密钥文件的路径和修改时间，新增、删除、修改任意一个文件都会变化
+func fileFingerprint() string {
+	files := fragmentFiles()
+	if path := configFile(); path != "" {
+		files = append([]string{path}, files...)
+	}
+	return filewatch.Fingerprint(append(files, LoadConfig().secretFiles...)...)
+}
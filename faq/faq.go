@@ -0,0 +1,221 @@
+package faq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/pkg/filewatch"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/rule"
+)
+
+const (
+	// MatchExact 问题与模式完全相同，忽略大小写、空格和标点
+	MatchExact = "exact"
+	// MatchKeyword 问题包含任意一个关键词
+	MatchKeyword = "keyword"
+	// MatchRegex 问题匹配任意一个正则
+	MatchRegex = "regex"
+	// MatchFuzzy 问题与任意一个模式的相似度不低于阈值
+	MatchFuzzy = "fuzzy"
+)
+
+// defaultThreshold 模糊匹配默认相似度阈值
+const defaultThreshold = 0.8
+
+// reloadInterval 检查规则文件是否修改的间隔
+const reloadInterval = 3 * time.Second
+
+// Rule FAQ规则
+type Rule struct {
+	// 匹配方式：exact、keyword、regex、fuzzy
+	Match string `json:"match"`
+	// 问题模式
+	Patterns []string `json:"patterns"`
+	// 模糊匹配的相似度阈值，0到1，默认0.8
+	Threshold float64 `json:"threshold"`
+	// 回答，支持模板变量：{{.Name}} 提问者，{{.Group}} 群名称，{{.Question}} 问题，{{.Time}} 当前时间
+	Answer string `json:"answer"`
+	// 生效的群名称，为空时对所有群和私聊生效
+	Groups []string `json:"groups"`
+
+	regexps  []*regexp.Regexp
+	template *template.Template
+}
+
+// Data 回答模板数据
+type Data struct {
+	// 提问者
+	Name string
+	// 群名称，私聊为空
+	Group string
+	// 问题
+	Question string
+	// 当前时间
+	Time time.Time
+}
+
+// rulesFile 规则文件，修改后自动重新加载，加载失败时继续使用原来的规则
+var rulesFile = filewatch.New("faq", reloadInterval, func(path string) (interface{}, error) {
+	rules, err := parseRules(path)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info(fmt.Sprintf("faq loaded %d rules from %s", len(rules), path))
+	return rules, nil
+})
+
+// Match 匹配FAQ规则，匹配成功返回渲染后的回答
+func Match(question string, data Data) (string, bool) {
+	for _, r := range loadRules() {
+		if len(r.Groups) > 0 && !rule.Grule.InSlice(data.Group, r.Groups) {
+			continue
+		}
+		if !r.match(question) {
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := r.template.Execute(&buf, data); err != nil {
+			logger.Warning(fmt.Sprintf("faq answer template error: %v", err))
+			return r.Answer, true
+		}
+		return buf.String(), true
+	}
+	return "", false
+}
+
+// loadRules 获取规则
+func loadRules() []*Rule {
+	rules, _ := rulesFile.Get(config.LoadConfig().FaqFile).([]*Rule)
+	return rules
+}
+
+// parseRules 读取并校验规则文件
+func parseRules(file string) ([]*Rule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var loaded []*Rule
+	if err = json.Unmarshal(data, &loaded); err != nil {
+		return nil, err
+	}
+
+	for i, r := range loaded {
+		switch r.Match {
+		case MatchExact, MatchKeyword, MatchFuzzy:
+		case MatchRegex:
+			for _, pattern := range r.Patterns {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					return nil, fmt.Errorf("rule %d: %v", i, err)
+				}
+				r.regexps = append(r.regexps, re)
+			}
+		default:
+			return nil, fmt.Errorf("rule %d: unknown match %q", i, r.Match)
+		}
+		if r.Threshold <= 0 {
+			r.Threshold = defaultThreshold
+		}
+		r.template, err = template.New(fmt.Sprintf("faq%d", i)).Parse(r.Answer)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i, err)
+		}
+	}
+	return loaded, nil
+}
+
+// match 判断问题是否匹配规则
+func (r *Rule) match(question string) bool {
+	normalized := normalize(question)
+	switch r.Match {
+	case MatchRegex:
+		for _, re := range r.regexps {
+			if re.MatchString(question) {
+				return true
+			}
+		}
+	default:
+		for _, pattern := range r.Patterns {
+			pattern = normalize(pattern)
+			if pattern == "" {
+				continue
+			}
+			switch r.Match {
+			case MatchExact:
+				if normalized == pattern {
+					return true
+				}
+			case MatchKeyword:
+				if strings.Contains(normalized, pattern) {
+					return true
+				}
+			case MatchFuzzy:
+				if similarity(normalized, pattern) >= r.Threshold {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// normalize 转为小写并去掉空格和标点
+func normalize(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, text)
+}
+
+// similarity 基于编辑距离计算两个字符串的相似度，0到1
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	return 1 - float64(prev[len(rb)])/float64(longest)
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -3,7 +3,9 @@ module github.com/qingconglaixueit/wechatbot
 go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/eatmoreapple/openwechat v1.2.1
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,81 @@
+package gpt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+// EmbeddingRequestBody embeddings请求体
+type EmbeddingRequestBody struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// EmbeddingResponseBody embeddings响应体
+type EmbeddingResponseBody struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+// Embeddings 获取文本的向量，返回的向量与inputs顺序一致
+func Embeddings(model string, inputs []string) ([][]float64, error) {
+	cfg := config.LoadConfig()
+	if cfg.ApiKey == "" {
+		return nil, errors.New("api key required")
+	}
+	requestData, err := json.Marshal(EmbeddingRequestBody{Model: model, Input: inputs})
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal requestBody error: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "https://api.openai.com/v1/embeddings", bytes.NewBuffer(requestData))
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest error: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+cfg.ApiKey)
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("client.Do error: %v", err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadAll error: %v", err)
+	}
+	var responseBody EmbeddingResponseBody
+	if err = json.Unmarshal(body, &responseBody); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal responseBody error: %v", err)
+	}
+	if responseBody.Error.Message != "" {
+		return nil, fmt.Errorf("embeddings error: %s", responseBody.Error.Message)
+	}
+	if len(responseBody.Data) != len(inputs) {
+		return nil, fmt.Errorf("embeddings error: got %d vectors for %d inputs", len(responseBody.Data), len(inputs))
+	}
+
+	vectors := make([][]float64, len(inputs))
+	for _, item := range responseBody.Data {
+		if item.Index < 0 || item.Index >= len(vectors) {
+			return nil, fmt.Errorf("embeddings error: invalid index %d", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	return vectors, nil
+}
@@ -1,16 +1,20 @@
 package gpt
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-    "io"
+	"io"
 	"net/http"
+	"strings"
 	"time"
-    "bufio"
+
 	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/pkg/metrics"
 )
 
 // ChatGPTResponseBody 响应体
@@ -30,57 +34,55 @@ type ChatGPTResponseBody struct {
 }
 
 type Choice struct {
-    Text string `json:"text"`
+	Text string `json:"text"`
 }
 
 type Event struct {
-    Choices []Choice `json:"choices"`
+	Choices []Choice `json:"choices"`
 }
 
 type StreamRes struct {
-    Data *CreateCompletionStreamingResponse `json:"data"`
+	Data *CreateCompletionStreamingResponse `json:"data"`
 }
 
 type CreateCompletionStreamingResponse struct {
-    ID        string             `json:"id,omitempty"`
-    Object    string             `json:"object,omitempty"`
-    CreatedAt int64              `json:"created_at,omitempty"`
-    Choices   []*StreamingChoice `json:"choices,omitempty"`
+	ID        string             `json:"id,omitempty"`
+	Object    string             `json:"object,omitempty"`
+	CreatedAt int64              `json:"created_at,omitempty"`
+	Choices   []*StreamingChoice `json:"choices,omitempty"`
 }
 
 type StreamingChoice struct {
-    Delta        *Message `json:"delta,omitempty"`
-    Index        int      `json:"index,omitempty"`
-    LogProbs     int      `json:"logprobs,omitempty"`
-    FinishReason string   `json:"finish_reason,omitempty"`
+	Delta        *Message `json:"delta,omitempty"`
+	Index        int      `json:"index,omitempty"`
+	LogProbs     int      `json:"logprobs,omitempty"`
+	FinishReason string   `json:"finish_reason,omitempty"`
 }
 
 type ChoiceItem struct {
-	Message      Message 	    `json:"message"`
-	Index        int    		`json:"index"`
-	FinishReason string 		`json:"finish_reason"`
+	Message      Message `json:"message"`
+	Index        int     `json:"index"`
+	FinishReason string  `json:"finish_reason"`
 }
 
 type Message struct {
-    Role    string `json:"role,omitempty"`
-    Content string `json:"content"`
-    Name    string `json:"name,omitempty"`
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content"`
+	Name    string `json:"name,omitempty"`
 }
 
 // ChatGPTRequestBody 请求体
 type ChatGPTRequestBody struct {
-	Model            string  `json:"model"`
-	MaxTokens        uint    `json:"max_tokens"`
-	Temperature      float64 `json:"temperature"`
-	TopP             int     `json:"top_p"`
-	FrequencyPenalty int     `json:"frequency_penalty"`
-	PresencePenalty  int     `json:"presence_penalty"`
-    Stream            bool    `json:"stream"`
-    Messages         []Message         `json:"messages"`
+	Model            string    `json:"model"`
+	MaxTokens        uint      `json:"max_tokens"`
+	Temperature      float64   `json:"temperature"`
+	TopP             int       `json:"top_p"`
+	FrequencyPenalty int       `json:"frequency_penalty"`
+	PresencePenalty  int       `json:"presence_penalty"`
+	Stream           bool      `json:"stream"`
+	Messages         []Message `json:"messages"`
 }
 
-
-
 // Completions gtp文本模型回复
 //curl https://api.openai.com/v1/completions
 //-H "Content-Type: application/json"
@@ -88,126 +90,156 @@ type ChatGPTRequestBody struct {
 //-d '{"model": "text-davinci-003", "prompt": "give me good song", "temperature": 0, "max_tokens": 7}'
 
 func Completions(msg string) (string, error) {
-	var resErr error
-    start := time.Now()
-    var reply string
-	reply, resErr = httpStreamRequestCompletions(msg, 1)
-	if resErr != nil {
-		return "", resErr
+	return CompletionsWithContext(context.Background(), msg)
+}
+
+// CompletionsWithContext 同Completions，ctx取消时中断请求
+func CompletionsWithContext(ctx context.Context, msg string) (string, error) {
+	return ChatCompletionsWithContext(ctx, []Message{{Role: "user", Content: msg}})
+}
+
+// ChatCompletions 以多轮对话消息请求gpt，messages不需要包含system消息
+func ChatCompletions(messages []Message) (string, error) {
+	return ChatCompletionsWithContext(context.Background(), messages)
+}
+
+// ChatCompletionsWithContext 同ChatCompletions，ctx取消时中断请求，ctx中带有请求日志时使用相同的请求ID记录日志
+func ChatCompletionsWithContext(ctx context.Context, messages []Message) (string, error) {
+	log := logger.FromContext(ctx)
+	model := config.LoadConfig().Model
+	start := time.Now()
+	reply, err := httpStreamRequestCompletions(ctx, messages, 1)
+	elapsed := time.Since(start)
+	metrics.GptLatency.Observe(elapsed.Seconds(), model)
+	if err != nil {
+		log.With("elapsed_ms", elapsed.Milliseconds(), "error", err).Warning("gpt request failed")
+		recordError(ctx, model, err)
+		return "", err
+	}
+	log.With("elapsed_ms", elapsed.Milliseconds(), "model", model, "reply", reply).Info("gpt response")
+	metrics.GptRequests.Inc(model, "success")
+	usage := recordUsage(model, messages, reply, elapsed)
+	if target, ok := ctx.Value(usageKey{}).(*Usage); ok {
+		*target = usage
 	}
-    elapsed := time.Since(start)
-    log.Printf("API response time: %s\n", elapsed)
 	return reply, nil
 }
 
-func httpStreamRequestCompletions(msg string, runtimes int) (string, error) {
-    cfg := config.LoadConfig()
-    if cfg.ApiKey == "" {
-        return "", errors.New("api key required")
-    }
-    requestBody := ChatGPTRequestBody{
-        Model:            cfg.Model,
-        MaxTokens:        cfg.MaxTokens,
-        Temperature:      cfg.Temperature,
-        TopP:             1,
-        FrequencyPenalty: 0,
-        PresencePenalty:  0,
-        Stream:           true,
-        Messages:        []Message{
-            {
-                Role:    "system",
-                Content: "You are a helpful assistant.",
-            },
-            {
-                Role:    "user",
-                Content: msg,
-            },
-        },
-    }
-    
-    
-    requestData, err := json.Marshal(requestBody)
-    if err != nil {
-        return "", fmt.Errorf("json.Marshal requestBody error: %v", err)
-    }
-    log.Printf("gpt request(%d) json: %s\n", runtimes, string(requestData))
-    
-    req, err := http.NewRequest(http.MethodPost, "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(requestData))
-    if err != nil {
-        return "", fmt.Errorf("http.NewRequest error: %v", err)
-    }
-    req.Header.Set("Content-Type", "application/json")
-    req.Header.Set("Authorization", "Bearer "+cfg.ApiKey)
-    
-    client := &http.Client{}
-    response, err := client.Do(req)
-    if err != nil {
-        return "", fmt.Errorf("client.Do error: %v", err)
-    }
-    // Close the response body
-    defer response.Body.Close()
-    
-    collectedMessages := make([]string, 0)
-
-    // Create a new buffered reader for the response body
-    reader := bufio.NewReader(response.Body)
-
-    // Loop through each line in the response
-    i := 1
-    for {
-        fmt.Println("for Loop %d",i)
-        i++
-        // Read a line from the response
-        line, err := reader.ReadBytes('\n')
-        
-        if err != nil {
-            if err == io.EOF {
-                break
-            }
-            return "", fmt.Errorf("ReadBytes error: %v", err)
-        }
-
-        
-        // Check if the line is the end of the stream
-        // Remove the newline character from the line
-        fmt.Println("Received JSON data:", string(line))
-        if len(line)>6 {
-            line = line[6:len(line)-1]
-        if string(line) == "[DONE]" {
-            fmt.Println("Stream finished")
-            break
-        }
-            // Otherwise, assume the line is JSON data
-            var collectedChunks CreateCompletionStreamingResponse
-            err = json.Unmarshal(line, &collectedChunks)
-            if err != nil {
-                return "", fmt.Errorf("Unmarshal error: %v", err)
-            }
-            fmt.Printf("collectedChunks: %+v\n", collectedChunks)
-
-            if collectedChunks.Choices != nil && len(collectedChunks.Choices) > 0  {
-                // Content字段为空
-                temp := collectedChunks.Choices[0]
-                fmt.Printf("temp: %+v\n", temp)
-                if temp.Delta != nil {
-                    tmp := temp.Delta
-                    fmt.Printf("tmp: %+v\n", tmp)
-                    if tmp.Content != ""{
-                        chunkMessage := tmp.Content // extract the message
-                        fmt.Println("no 202" + chunkMessage)
-                        collectedMessages = append(collectedMessages, chunkMessage) // save the message
-                    }
-                }
-            }
-        }
-    }
-
-    // print the time delay and text received
-    fullReplyContent := ""
-    for _, message := range collectedMessages {
-        fullReplyContent += message
-    }
-    fmt.Printf("Full conversation received: %s\n", fullReplyContent)
-    return fullReplyContent, nil
+// recordError 按错误分类记录失败的请求，用户撤回消息取消的请求不算错误
+func recordError(ctx context.Context, model string, err error) {
+	switch {
+	case ctx.Err() == context.Canceled:
+		metrics.GptRequests.Inc(model, "canceled")
+		return
+	case ctx.Err() == context.DeadlineExceeded || strings.Contains(err.Error(), "deadline exceeded") || strings.Contains(err.Error(), "Timeout"):
+		metrics.Errors.Inc("gpt_timeout")
+	default:
+		metrics.Errors.Inc("gpt_error")
+	}
+	metrics.GptRequests.Inc(model, "error")
 }
 
+func httpStreamRequestCompletions(ctx context.Context, messages []Message, runtimes int) (string, error) {
+	log := logger.FromContext(ctx)
+	cfg := config.LoadConfig()
+	if cfg.ApiKey == "" {
+		return "", errors.New("api key required")
+	}
+	requestBody := ChatGPTRequestBody{
+		Model:            cfg.Model,
+		MaxTokens:        cfg.MaxTokens,
+		Temperature:      cfg.Temperature,
+		TopP:             1,
+		FrequencyPenalty: 0,
+		PresencePenalty:  0,
+		Stream:           true,
+		Messages: append([]Message{
+			{
+				Role:    "system",
+				Content: "You are a helpful assistant.",
+			},
+		}, messages...),
+	}
+
+	requestData, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal requestBody error: %v", err)
+	}
+	log.With("runtimes", runtimes, "model", cfg.Model, "messages", len(requestBody.Messages)).DeBug("gpt request")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(requestData))
+	if err != nil {
+		return "", fmt.Errorf("http.NewRequest error: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+cfg.ApiKey)
+
+	client := &http.Client{}
+	response, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("client.Do error: %v", err)
+	}
+	// Close the response body
+	defer response.Body.Close()
+
+	collectedMessages := make([]string, 0)
+
+	// Create a new buffered reader for the response body
+	reader := bufio.NewReader(response.Body)
+
+	// Loop through each line in the response
+	chunks := 0
+	for {
+		// Read a line from the response
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return "", fmt.Errorf("ReadBytes error: %v", err)
+		}
+
+		// Check if the line is the end of the stream
+		// Remove the newline character from the line
+		if len(line) > 6 {
+			line = line[6 : len(line)-1]
+			if string(line) == "[DONE]" {
+				break
+			}
+			// Otherwise, assume the line is JSON data
+			var collectedChunks CreateCompletionStreamingResponse
+			err = json.Unmarshal(line, &collectedChunks)
+			if err != nil {
+				return "", fmt.Errorf("Unmarshal error: %v", err)
+			}
+			chunks++
+
+			if len(collectedChunks.Choices) > 0 {
+				// Content字段为空
+				temp := collectedChunks.Choices[0]
+				if temp.Delta != nil && temp.Delta.Content != "" {
+					collectedMessages = append(collectedMessages, temp.Delta.Content) // save the message
+				}
+			}
+		}
+	}
+
+	// print the time delay and text received
+	fullReplyContent := strings.Join(collectedMessages, "")
+	log.With("chunks", chunks, "reply", fullReplyContent).DeBug("gpt stream finished")
+	return fullReplyContent, nil
+}
+
+// MessageName 将昵称转换为接口允许的name字段，只保留字母、数字、下划线和中划线，最长64个字符
+func MessageName(nickName string) string {
+	name := make([]rune, 0, len(nickName))
+	for _, r := range nickName {
+		if len(name) >= 64 {
+			break
+		}
+		if r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			name = append(name, r)
+		}
+	}
+	return string(name)
+}
@@ -0,0 +1,75 @@
+package gpt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+// ModerationResponseBody moderations响应体
+type ModerationResponseBody struct {
+	Results []struct {
+		Flagged    bool            `json:"flagged"`
+		Categories map[string]bool `json:"categories"`
+	} `json:"results"`
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+// Moderation 调用内容审核接口，返回是否违规以及违规的类别
+func Moderation(input string) (bool, []string, error) {
+	cfg := config.LoadConfig()
+	if cfg.ApiKey == "" {
+		return false, nil, errors.New("api key required")
+	}
+	requestData, err := json.Marshal(map[string]string{"input": input})
+	if err != nil {
+		return false, nil, fmt.Errorf("json.Marshal requestBody error: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "https://api.openai.com/v1/moderations", bytes.NewBuffer(requestData))
+	if err != nil {
+		return false, nil, fmt.Errorf("http.NewRequest error: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+cfg.ApiKey)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(req)
+	if err != nil {
+		return false, nil, fmt.Errorf("client.Do error: %v", err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return false, nil, fmt.Errorf("ioutil.ReadAll error: %v", err)
+	}
+	var responseBody ModerationResponseBody
+	if err = json.Unmarshal(body, &responseBody); err != nil {
+		return false, nil, fmt.Errorf("json.Unmarshal responseBody error: %v", err)
+	}
+	if responseBody.Error.Message != "" {
+		return false, nil, fmt.Errorf("moderation error: %s", responseBody.Error.Message)
+	}
+
+	flagged := false
+	categories := make([]string, 0)
+	for _, result := range responseBody.Results {
+		flagged = flagged || result.Flagged
+		for category, hit := range result.Categories {
+			if hit {
+				categories = append(categories, category)
+			}
+		}
+	}
+	return flagged, categories, nil
+}
@@ -0,0 +1,36 @@
+package gpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+// Ping 检查GPT服务是否可以访问，请求模型列表接口，不消耗token
+func Ping(ctx context.Context) error {
+	cfg := config.LoadConfig()
+	if cfg.ApiKey == "" {
+		return errors.New("api key required")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.openai.com/v1/models/"+cfg.Model, nil)
+	if err != nil {
+		return fmt.Errorf("http.NewRequest error: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.ApiKey)
+
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("client.Do error: %v", err)
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("models api returned %s", response.Status)
+	}
+	return nil
+}
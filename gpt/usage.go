@@ -0,0 +1,119 @@
+package gpt
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/pkg/metrics"
+)
+
+// usageFile 用量记录文件，位于数据目录下，每行一条JSON
+const usageFile = "usage.jsonl"
+
+// Usage 一次请求的用量，流式接口不返回用量，token数为估算值
+type Usage struct {
+	// 请求时间
+	Time time.Time `json:"time"`
+	// 模型
+	Model string `json:"model"`
+	// 提问的token数
+	PromptTokens int `json:"prompt_tokens"`
+	// 回复的token数
+	CompletionTokens int `json:"completion_tokens"`
+	// 耗时，单位毫秒
+	Duration int64 `json:"duration"`
+}
+
+var usageLock sync.Mutex
+
+// usageKey 上下文中保存用量的key
+type usageKey struct{}
+
+// WithUsage 请求完成后把这次请求的用量写入usage，用于审计日志等需要按消息统计用量的地方
+func WithUsage(ctx context.Context, usage *Usage) context.Context {
+	return context.WithValue(ctx, usageKey{}, usage)
+}
+
+// recordUsage 记录一次请求的用量，失败只打印日志，返回这次请求的用量
+func recordUsage(model string, messages []Message, reply string, elapsed time.Duration) Usage {
+	usage := Usage{
+		Time:             time.Now(),
+		Model:            model,
+		CompletionTokens: EstimateTokens(reply),
+		Duration:         elapsed.Milliseconds(),
+	}
+	for _, message := range messages {
+		usage.PromptTokens += EstimateTokens(message.Content)
+	}
+	metrics.GptTokens.Add(float64(usage.PromptTokens), model, "prompt")
+	metrics.GptTokens.Add(float64(usage.CompletionTokens), model, "completion")
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return usage
+	}
+
+	usageLock.Lock()
+	defer usageLock.Unlock()
+	path := config.LoadConfig().DataPath(usageFile)
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logger.Warning(fmt.Sprintf("record usage error: %v", err))
+		return usage
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		logger.Warning(fmt.Sprintf("record usage error: %v", err))
+		return usage
+	}
+	defer f.Close()
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		logger.Warning(fmt.Sprintf("record usage error: %v", err))
+	}
+	return usage
+}
+
+// ReadUsage 读取since之后的用量记录
+func ReadUsage(since time.Time) ([]Usage, error) {
+	f, err := os.Open(config.LoadConfig().DataPath(usageFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	usages := make([]Usage, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var usage Usage
+		if err := json.Unmarshal(scanner.Bytes(), &usage); err != nil {
+			continue
+		}
+		if !usage.Time.Before(since) {
+			usages = append(usages, usage)
+		}
+	}
+	return usages, scanner.Err()
+}
+
+// EstimateTokens 估算文本的token数，中文等字符每个字约1个token，英文约4个字符1个token
+func EstimateTokens(text string) int {
+	wide, narrow := 0, 0
+	for _, r := range text {
+		if r < unicode.MaxASCII {
+			narrow++
+		} else {
+			wide++
+		}
+	}
+	return wide + (narrow+3)/4
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/audit"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+)
+
+// auditKey 消息上下文中保存审计信息的key
+const auditKey = "audit"
+
+// auditEntry 处理一条消息过程中收集的审计信息，回复发送后写入审计日志
+type auditEntry struct {
+	// 收到消息的时间
+	received time.Time
+	// 聊天类型、聊天名称、发送者
+	chatType, chat, sender string
+	// 收到的原始消息内容，审核mask时会替换消息内容，所以收到时先保存
+	question string
+	// 请求GPT的用量，没有请求GPT时为空
+	usage gpt.Usage
+	// 请求GPT的错误
+	err error
+}
+
+// beginAudit 收到消息时记录收到的时间、聊天类型和原始消息内容
+func beginAudit(msg *openwechat.Message) {
+	chatType := audit.ChatPrivate
+	if msg.IsComeFromGroup() {
+		chatType = audit.ChatGroup
+	}
+	msg.Set(auditKey, &auditEntry{received: time.Now(), chatType: chatType, question: msg.Content})
+}
+
+// getAudit 获取消息的审计信息，没有时返回nil
+func getAudit(msg *openwechat.Message) *auditEntry {
+	if value, ok := msg.Get(auditKey); ok {
+		return value.(*auditEntry)
+	}
+	return nil
+}
+
+// setAuditChat 记录聊天名称和发送者，私聊的聊天名称为好友昵称
+func setAuditChat(msg *openwechat.Message, chat, sender string) {
+	if entry := getAudit(msg); entry != nil {
+		entry.chat, entry.sender = chat, sender
+	}
+}
+
+// setAuditError 记录请求GPT的错误
+func setAuditError(msg *openwechat.Message, err error) {
+	if entry := getAudit(msg); entry != nil {
+		entry.err = err
+	}
+}
+
+// auditUsage 请求GPT时写入用量的位置
+func auditUsage(msg *openwechat.Message) *gpt.Usage {
+	if entry := getAudit(msg); entry != nil {
+		return &entry.usage
+	}
+	return nil
+}
+
+// writeAudit 回复发送后写入审计日志，sendErr为发送回复的错误
+func writeAudit(msg *openwechat.Message, reply string, sendErr error) {
+	entry := getAudit(msg)
+	if entry == nil {
+		return
+	}
+	id, _ := msg.Get(requestIDKey)
+	requestID, _ := id.(string)
+	record := audit.Record{
+		Time:             entry.received,
+		RequestID:        requestID,
+		MsgID:            msg.MsgId,
+		ChatType:         entry.chatType,
+		Chat:             entry.chat,
+		Sender:           entry.sender,
+		Question:         entry.question,
+		Reply:            reply,
+		Model:            entry.usage.Model,
+		PromptTokens:     entry.usage.PromptTokens,
+		CompletionTokens: entry.usage.CompletionTokens,
+		GptLatency:       entry.usage.Duration,
+		Latency:          time.Since(entry.received).Milliseconds(),
+	}
+	switch {
+	case entry.err != nil:
+		record.Error = entry.err.Error()
+	case sendErr != nil:
+		record.Error = sendErr.Error()
+	}
+	audit.Write(record)
+}
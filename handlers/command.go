@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/service"
+)
+
+// defaultSummaryHours 群聊摘要默认总结最近几个小时的聊天
+const defaultSummaryHours = 2
+
+// groupCommand 群指令处理函数，args为指令后面的参数，返回回复的文本
+type groupCommand func(g *GroupMessageHandler, args []string) string
+
+// groupCommands 群指令，@机器人并以指令开头时触发
+var groupCommands = map[string]groupCommand{
+	"/mode":    modeCommand,
+	"/summary": summaryCommand,
+	"/总结":      summaryCommand,
+}
+
+// parseCommand 解析指令，返回指令名称以及参数，不是指令时ok为false
+func parseCommand(text string) (name string, args []string, ok bool) {
+	if !strings.HasPrefix(text, "/") {
+		return "", nil, false
+	}
+	fields := strings.Fields(text)
+	return strings.ToLower(fields[0]), fields[1:], true
+}
+
+// modeCommand 查看或切换群会话模式：/mode shared 全群共享会话，/mode personal 每个人独立会话
+func modeCommand(g *GroupMessageHandler, args []string) string {
+	if len(args) == 0 {
+		if g.groupService.IsSharedSession() {
+			return "当前为全群共享会话模式，发送 /mode personal 切换为独立会话"
+		}
+		return "当前为独立会话模式，发送 /mode shared 切换为全群共享会话"
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "shared", "共享":
+		g.groupService.SetSharedSession(true)
+		return "已切换为全群共享会话模式，大家的消息会作为同一个上下文"
+	case "personal", "独立":
+		g.groupService.SetSharedSession(false)
+		return "已切换为独立会话模式，每个人的上下文互不影响"
+	default:
+		return "未知的会话模式，可选：shared（全群共享）、personal（独立会话）"
+	}
+}
+
+// summaryCommand 总结群里最近的聊天记录：/summary [N]，N为小时数，默认2小时
+func summaryCommand(g *GroupMessageHandler, args []string) string {
+	hours := float64(defaultSummaryHours)
+	if len(args) > 0 {
+		h, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(args[0]), "h"), 64)
+		if err != nil || h <= 0 {
+			return "时间格式错误，示例：/summary 3 表示总结最近3小时的聊天"
+		}
+		hours = h
+	}
+
+	since := time.Now().Add(-time.Duration(hours * float64(time.Hour)))
+	digest, err := g.groupService.SummarizeGroupMessages(since)
+	if errors.Is(err, service.ErrNoGroupMessages) {
+		return fmt.Sprintf("最近%v小时没有聊天记录", hours)
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "context deadline exceeded") {
+			return deadlineExceededText
+		}
+		return err.Error()
+	}
+	return fmt.Sprintf("最近%v小时的群聊摘要：\n%s", hours, digest)
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/rule"
+	"github.com/qingconglaixueit/wechatbot/service"
+)
+
+// digestCheckInterval 定时摘要检查间隔
+const digestCheckInterval = time.Minute
+
+// digestJob 定时摘要任务
+type digestJob struct {
+	// 任务配置
+	schedule config.DigestSchedule
+	// 每天发送的时间
+	hour, minute int
+	// 每周发送的星期，每天发送时为nil
+	weekday *time.Weekday
+	// 上次到达发送时间的日期，避免同一天重复发送
+	lastRun string
+	// 已经到了发送时间但还没有发送，不在工作时间时一直推迟到下一个工作时间
+	pending bool
+}
+
+var (
+	// digestJobs 当前的定时摘要任务，配置更新后重新生成
+	digestJobs []*digestJob
+	digestLock sync.Mutex
+)
+
+// StartDigestScheduler 启动定时群聊摘要，按配置的时间把摘要发送到指定的群，不在工作时间时推迟到工作时间再发送
+func StartDigestScheduler(self *openwechat.Self) {
+	setDigestJobs(config.LoadConfig().DigestSchedules)
+	config.Subscribe(func(old, cfg *config.Configuration) {
+		if !reflect.DeepEqual(old.DigestSchedules, cfg.DigestSchedules) {
+			setDigestJobs(cfg.DigestSchedules)
+		}
+	})
+
+	go func() {
+		ticker := time.NewTicker(digestCheckInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			// 持有锁判断和修改发送状态，生成摘要耗时较长，释放锁后再发送
+			dueJobs := make([]*digestJob, 0)
+			digestLock.Lock()
+			for _, job := range digestJobs {
+				if job.due(now, isDigestWorkTime) {
+					dueJobs = append(dueJobs, job)
+				}
+			}
+			digestLock.Unlock()
+			for _, job := range dueJobs {
+				job.run(self, now)
+			}
+		}
+	}()
+}
+
+// setDigestJobs 根据配置生成定时摘要任务，没有修改的任务保留发送状态，推迟中的摘要不会丢失
+func setDigestJobs(schedules []config.DigestSchedule) {
+	digestLock.Lock()
+	defer digestLock.Unlock()
+	jobs := make([]*digestJob, 0, len(schedules))
+	for _, schedule := range schedules {
+		job, err := newDigestJob(schedule, time.Now())
+		if err != nil {
+			logger.Warning(fmt.Sprintf("digest schedule error: %v", err))
+			continue
+		}
+		for _, old := range digestJobs {
+			if reflect.DeepEqual(old.schedule, schedule) {
+				job.lastRun, job.pending = old.lastRun, old.pending
+				break
+			}
+		}
+		jobs = append(jobs, job)
+	}
+	digestJobs = jobs
+}
+
+// newDigestJob 解析定时摘要配置，启动时已经过了今天的发送时间，则从下一次开始发送
+func newDigestJob(schedule config.DigestSchedule, now time.Time) (*digestJob, error) {
+	at, err := time.Parse("15:04", schedule.Time)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time %q: %v", schedule.Time, err)
+	}
+	job := &digestJob{schedule: schedule, hour: at.Hour(), minute: at.Minute()}
+
+	if schedule.Weekday != "" {
+		weekday, ok := parseWeekday(schedule.Weekday)
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday %q", schedule.Weekday)
+		}
+		job.weekday = &weekday
+	}
+	if job.scheduledAt(now).Before(now) {
+		job.lastRun = now.Format("2006-01-02")
+	}
+	return job, nil
+}
+
+// parseWeekday 解析星期，支持英文全称及缩写
+func parseWeekday(text string) (time.Weekday, bool) {
+	text = strings.ToLower(text)
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := strings.ToLower(day.String())
+		if text == name || text == name[:3] {
+			return day, true
+		}
+	}
+	return time.Sunday, false
+}
+
+// scheduledAt 当天的发送时间
+func (j *digestJob) scheduledAt(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), now.Day(), j.hour, j.minute, 0, 0, now.Location())
+}
+
+// due 是否需要发送：到了发送时间并且星期匹配时记为待发送，待发送的任务在工作时间内发送，返回true后清除待发送
+// 发送时间不在工作时间内时（如work_end_hour之后），推迟到下一个工作时间发送，推迟期间再次到达发送时间只发送一次
+// working判断now是否在工作时间，测试时可以传入固定的工作时间
+func (j *digestJob) due(now time.Time, working func(now time.Time) bool) bool {
+	today := now.Format("2006-01-02")
+	if j.lastRun != today && !now.Before(j.scheduledAt(now)) && (j.weekday == nil || now.Weekday() == *j.weekday) {
+		j.lastRun = today
+		j.pending = true
+	}
+	if !j.pending || !working(now) {
+		return false
+	}
+	j.pending = false
+	return true
+}
+
+// isDigestWorkTime 是否在工作时间，机器人下班时不发送摘要
+func isDigestWorkTime(time.Time) bool {
+	cfg := config.LoadConfig()
+	return rule.Grule.GetWork() && rule.Grule.IsWorkTime(cfg.WorkStartHour, cfg.WorkEndHour)
+}
+
+// run 生成摘要并发送到配置的群
+func (j *digestJob) run(self *openwechat.Self, now time.Time) {
+	hours := j.schedule.Hours
+	if hours <= 0 {
+		hours = 24
+		if j.weekday != nil {
+			hours = 24 * 7
+		}
+	}
+
+	groups, err := self.Groups()
+	if err != nil {
+		logger.Warning(fmt.Sprintf("digest get groups error: %v", err))
+		return
+	}
+	for _, name := range j.schedule.Groups {
+		group := groups.GetByNickName(name)
+		if group == nil {
+			logger.Warning(fmt.Sprintf("digest group not found: %s", name))
+			continue
+		}
+
+		groupService := service.NewGroupService(c, group)
+		digest, err := groupService.SummarizeGroupMessages(now.Add(-time.Duration(hours) * time.Hour))
+		if errors.Is(err, service.ErrNoGroupMessages) {
+			continue
+		}
+		if err != nil {
+			logger.Warning(fmt.Sprintf("digest summarize group %s error: %v", name, err))
+			continue
+		}
+
+		// 摘要是GPT生成的内容，和回复一样需要审核，拒绝时不发送
+		digest, ok := moderateOutput(self.Bot, name, "群聊摘要", digest)
+		if !ok {
+			logger.Warning(fmt.Sprintf("digest of group %s refused by moderation", name))
+			continue
+		}
+		_, err = sendText(group.SendText, time.Now(), fmt.Sprintf("最近%d小时的群聊摘要：\n%s", hours, digest))
+		if err != nil {
+			logger.Warning(fmt.Sprintf("digest send to group %s error: %v", name, err))
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+func TestDigestJobDue(t *testing.T) {
+	// 工作时间为9点到21点，weekdays周末不工作
+	weekdays := func(now time.Time) bool {
+		return now.Weekday() != time.Saturday && now.Weekday() != time.Sunday && now.Hour() >= 9 && now.Hour() < 21
+	}
+	everyday := func(now time.Time) bool {
+		return now.Hour() >= 9 && now.Hour() < 21
+	}
+	// 2023-03-03是星期五
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2023, 3, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name       string
+		schedule   config.DigestSchedule
+		working    func(now time.Time) bool
+		start, end time.Time
+		want       []time.Time
+	}{
+		{
+			name:     "inside work window",
+			schedule: config.DigestSchedule{Time: "10:00"},
+			working:  everyday,
+			start:    at(2, 8, 0),
+			end:      at(4, 8, 0),
+			want:     []time.Time{at(2, 10, 0), at(3, 10, 0)},
+		},
+		{
+			name:     "started after today's time",
+			schedule: config.DigestSchedule{Time: "10:00"},
+			working:  everyday,
+			start:    at(2, 11, 0),
+			end:      at(3, 12, 0),
+			want:     []time.Time{at(3, 10, 0)},
+		},
+		{
+			name:     "outside work window carried to next opening",
+			schedule: config.DigestSchedule{Time: "22:30"},
+			working:  everyday,
+			start:    at(2, 8, 0),
+			end:      at(4, 12, 0),
+			want:     []time.Time{at(3, 9, 0), at(4, 9, 0)},
+		},
+		{
+			name:     "weekly digest missed across weekend",
+			schedule: config.DigestSchedule{Time: "22:00", Weekday: "friday"},
+			working:  weekdays,
+			start:    at(3, 8, 0),
+			end:      at(7, 8, 0),
+			want:     []time.Time{at(6, 9, 0)},
+		},
+		{
+			name:     "daily digests missed across weekend sent once",
+			schedule: config.DigestSchedule{Time: "22:00"},
+			working:  weekdays,
+			start:    at(3, 8, 0),
+			end:      at(6, 12, 0),
+			want:     []time.Time{at(6, 9, 0)},
+		},
+		{
+			name:     "no double send after carry-over fires",
+			schedule: config.DigestSchedule{Time: "22:00"},
+			working:  everyday,
+			start:    at(3, 21, 0),
+			end:      at(4, 21, 59),
+			want:     []time.Time{at(4, 9, 0)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			job, err := newDigestJob(test.schedule, test.start)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 按定时任务的检查间隔模拟每分钟检查一次
+			var fired []time.Time
+			for now := test.start; !now.After(test.end); now = now.Add(digestCheckInterval) {
+				if job.due(now, test.working) {
+					fired = append(fired, now)
+				}
+			}
+			if !reflect.DeepEqual(fired, test.want) {
+				t.Errorf("fired at %v, want %v", fired, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+)
+
+// FriendAddMessageContextHandler 好友申请，验证消息匹配关键词或正则才通过，通过后设置备注、打招呼并邀请入群
+func FriendAddMessageContextHandler() func(ctx *openwechat.MessageContext) {
+	return func(ctx *openwechat.MessageContext) {
+		msg := ctx.Message
+		if !config.LoadConfig().AutoPass {
+			return
+		}
+		cfg := config.LoadConfig().FriendRequest
+
+		// 1.校验验证消息
+		content, err := msg.FriendAddMessageContent()
+		if err != nil {
+			logger.Warning(fmt.Sprintf("add friend parse content error : %v", err))
+			return
+		}
+		if !matchVerifyContent(content.Content, cfg) {
+			logger.With("from", content.FromNickName, "content", content.Content).Info("add friend rejected")
+			return
+		}
+
+		// 2.通过好友申请
+		friend, err := msg.Agree("")
+		if err != nil {
+			logger.Warning(fmt.Sprintf("add friend agree error : %v", err))
+			return
+		}
+
+		// 3.设置备注名
+		if cfg.RemarkName != "" {
+			remarkName := renderFriendTemplate(cfg.RemarkName, friend, content.Content)
+			if err = friend.SetRemarkName(remarkName); err != nil {
+				logger.Warning(fmt.Sprintf("add friend set remark name error : %v", err))
+			}
+		}
+
+		// 4.打招呼
+		if cfg.Greeting != "" {
+			if _, err = sendText(friend.SendText, time.Now(), renderFriendTemplate(cfg.Greeting, friend, content.Content)); err != nil {
+				logger.Warning(fmt.Sprintf("add friend send greeting error : %v", err))
+			}
+		}
+
+		// 5.邀请入群
+		if len(cfg.InviteGroups) > 0 {
+			inviteFriend(friend, cfg.InviteGroups)
+		}
+	}
+}
+
+// matchVerifyContent 验证消息是否包含任意关键词或匹配任意正则，都没有配置时全部通过
+func matchVerifyContent(content string, cfg config.FriendRequestConfig) bool {
+	if len(cfg.Keywords) == 0 && len(cfg.Patterns) == 0 {
+		return true
+	}
+	for _, keyword := range cfg.Keywords {
+		if keyword != "" && strings.Contains(content, keyword) {
+			return true
+		}
+	}
+	for _, expr := range cfg.Patterns {
+		pattern, err := compileTrigger(expr)
+		if err != nil {
+			logger.Warning(fmt.Sprintf("friend request pattern error: %v", err))
+			continue
+		}
+		if pattern.MatchString(content) {
+			return true
+		}
+	}
+	return false
+}
+
+// renderFriendTemplate 渲染备注名或招呼模板，支持{{.NickName}}昵称、{{.Content}}验证消息
+func renderFriendTemplate(text string, friend *openwechat.Friend, content string) string {
+	tmpl, err := template.New("friend").Parse(text)
+	if err != nil {
+		logger.Warning(fmt.Sprintf("friend request template error: %v", err))
+		return text
+	}
+	var buf bytes.Buffer
+	data := map[string]string{"NickName": friend.NickName, "Content": content}
+	if err = tmpl.Execute(&buf, data); err != nil {
+		logger.Warning(fmt.Sprintf("friend request template error: %v", err))
+		return text
+	}
+	return buf.String()
+}
+
+// inviteFriend 邀请好友进入配置的群
+func inviteFriend(friend *openwechat.Friend, names []string) {
+	groups, err := friend.Self.Groups()
+	if err != nil {
+		logger.Warning(fmt.Sprintf("add friend get groups error : %v", err))
+		return
+	}
+	for _, name := range names {
+		group := groups.GetByNickName(name)
+		if group == nil {
+			logger.Warning(fmt.Sprintf("add friend invite group not found: %s", name))
+			continue
+		}
+		if err = friend.AddIntoGroup(group); err != nil {
+			logger.Warning(fmt.Sprintf("add friend invite into group %s error : %v", name, err))
+		}
+	}
+}
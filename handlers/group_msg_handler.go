@@ -2,13 +2,14 @@ package handlers
 
 import (
 	"fmt"
-	"log"
-	"math/rand"
 	"strings"
 	"time"
 
 	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/faq"
 	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/knowledge"
 	"github.com/qingconglaixueit/wechatbot/pkg/logger"
 	"github.com/qingconglaixueit/wechatbot/service"
 )
@@ -27,6 +28,10 @@ type GroupMessageHandler struct {
 	sender *openwechat.User
 	// 实现的用户业务
 	service service.UserServiceInterface
+	// 实现的群业务
+	groupService service.GroupServiceInterface
+	// 通过唤醒词前缀触发时的前缀，提问时需要去掉
+	triggerPrefix string
 }
 
 func GroupMessageContextHandler() func(ctx *openwechat.MessageContext) {
@@ -60,12 +65,14 @@ func NewGroupMessageHandler(msg *openwechat.Message) (MessageHandlerInterface, e
 	}
 
 	userService := service.NewUserService(c, groupSender)
+	groupService := service.NewGroupService(c, group)
 	handler := &GroupMessageHandler{
-		self:    sender.Self,
-		msg:     msg,
-		group:   group,
-		sender:  groupSender,
-		service: userService,
+		self:         sender.Self,
+		msg:          msg,
+		group:        group,
+		sender:       groupSender,
+		service:      userService,
+		groupService: groupService,
 	}
 	return handler, nil
 
@@ -74,77 +81,266 @@ func NewGroupMessageHandler(msg *openwechat.Message) (MessageHandlerInterface, e
 // handle 处理消息
 func (g *GroupMessageHandler) handle() error {
 	if g.msg.IsText() {
+		g.recordMessage()
 		return g.ReplyText()
 	}
 	return nil
 }
 
+// recordMessage 记录群消息用于生成群聊摘要，机器人指令和排队时已经撤回的消息不记录
+func (g *GroupMessageHandler) recordMessage() {
+	if IsRecalled(g.msg) {
+		return
+	}
+	quote, question := g.getQuestion()
+	if _, _, ok := parseCommand(question); ok {
+		return
+	}
+	content := question
+	if quote != nil {
+		content = "回复" + quote.author + "「" + quote.text + "」：" + question
+	}
+	if content == "" {
+		return
+	}
+	// 记录的消息会发送给GPT生成摘要，同样需要审核，拒绝时不记录
+	content, ok := moderateRecord(g.group.NickName, g.senderName(), content)
+	if !ok {
+		return
+	}
+	g.groupService.RecordGroupMessage(g.msg.MsgId, g.senderName(), content, time.Unix(g.msg.CreateTime, 0))
+}
+
 // ReplyText 发息送文本消到群
 func (g *GroupMessageHandler) ReplyText() error {
-	if time.Now().Unix()-g.msg.CreateTime > 60 {
+	if isStaleMessage(g.msg) {
 		return nil
 	}
 
-	maxInt := rand.New(rand.NewSource(time.Now().UnixNano())).Intn(5)
-	time.Sleep(time.Duration(maxInt+1) * time.Second)
-
-	log.Printf("Received Group[%v], Content[%v], CreateTime[%v]", g.group.NickName, g.msg.Content,
-		time.Unix(g.msg.CreateTime, 0).Format("2006/01/02 15:04:05"))
+	requestLogger(g.msg).With("group", g.group.NickName, "sender", g.senderName(), "content", g.msg.Content,
+		"create_time", time.Unix(g.msg.CreateTime, 0).Format("2006/01/02 15:04:05")).Info("received group message")
+	setAuditChat(g.msg, g.group.NickName, g.senderName())
 
 	var (
 		err   error
 		reply string
 	)
 
-	// 1.不是@的不处理
+	// 1.不是@的匹配触发规则，没有匹配到不处理，全群共享会话模式下记录到群上下文，让机器人能跟上大家的讨论
 	if !g.msg.IsAt() {
-		return nil
+		trigger, prefix := matchTrigger(g.group.NickName, strings.TrimSpace(g.msg.Content))
+		if trigger == nil {
+			if g.groupService.IsSharedSession() {
+				if quote, question := g.getQuestion(); quote != nil || question != "" {
+					msgID := g.msg.MsgId
+					g.groupService.AppendGroupSessionContext(msgID, g.buildSharedMessage(buildQuoteRequest(quote, question)))
+					trackReply(g.msg, nil, func() { g.groupService.RemoveGroupSessionContext(msgID) })
+				}
+			}
+			return nil
+		}
+
+		// 配置了固定回复的直接回复
+		if trigger.Reply != "" {
+			_, err = replyText(g.msg, "@"+g.sender.NickName+" "+trigger.Reply)
+			if err != nil {
+				return fmt.Errorf("reply group error: %v", err)
+			}
+			return nil
+		}
+		g.triggerPrefix = prefix
+	}
+
+	// 2.审核用户提问，拒绝时回复提示
+	if !moderateInput(g.msg, g.group.NickName, g.senderName()) {
+		return g.refuse()
+	}
+
+	// 3.群指令直接回复指令结果，指令结果可能包含群聊内容，同样需要审核
+	if _, question := g.getQuestion(); question != "" {
+		if name, args, ok := parseCommand(question); ok {
+			if command, exist := groupCommands[name]; exist {
+				result, ok := g.moderateReply(command(g, args))
+				if !ok {
+					return g.refuse()
+				}
+				_, err = replyText(g.msg, "@"+g.sender.NickName+"\n"+result)
+				if err != nil {
+					return fmt.Errorf("reply group error: %v", err)
+				}
+				return nil
+			}
+		}
+	}
+
+	// 4.匹配到FAQ的直接回复，不请求GPT
+	if _, question := g.getQuestion(); question != "" {
+		data := faq.Data{Name: g.senderName(), Group: g.group.NickName, Question: question, Time: time.Now()}
+		if answer, ok := faq.Match(question, data); ok {
+			sent, err := replyText(g.msg, g.buildReplyText(answer))
+			trackReply(g.msg, sent, nil)
+			if err != nil && err != errMessageRecalled {
+				return fmt.Errorf("reply group error: %v", err)
+			}
+			return nil
+		}
+	}
+
+	// 5.全群共享会话模式下使用群上下文回复
+	if g.groupService.IsSharedSession() {
+		return g.replySharedText()
 	}
 
-	// 2.获取请求的文本，如果为空字符串不处理
+	// 6.获取请求的文本，如果为空字符串不处理
 	requestText := g.getRequestText()
 	if requestText == "" {
-		log.Println("group message is empty")
+		requestLogger(g.msg).Info("group message is empty")
 		return nil
 	}
 
-	// 3.请求GPT获取回复
-	reply, err = gpt.Completions(requestText)
+	// 7.请求GPT获取回复，提问中的隐私信息替换为占位符，启用了知识库的群带上检索到的资料
+	redactor := newRedactor()
+	prompt := redactor.Redact(requestText)
+	if knowledge.Enabled(g.group.NickName) {
+		_, question := g.getQuestion()
+		prompt = knowledge.BuildPrompt(redactor.Redact(question), prompt)
+	}
+	ctx, done := beginRequest(g.msg)
+	defer done()
+	reply, err = gpt.CompletionsWithContext(ctx, prompt)
+	if ctx.Err() != nil {
+		requestLogger(g.msg).Info("group message recalled, cancel reply")
+		return nil
+	}
 	if err != nil {
-		text := err.Error()
-		if strings.Contains(err.Error(), "context deadline exceeded") {
-			text = deadlineExceededText
-		}
-		_, err = g.msg.ReplyText(text)
-		if err != nil {
-			return fmt.Errorf("reply group error: %v", err)
-		}
-		return err
+		return g.replyError(err)
 	}
 
-	// 4.设置上下文，并响应信息给用户
-	g.service.SetUserSessionContext(requestText, reply)
-	_, err = g.msg.ReplyText(g.buildReplyText(reply))
+	// 8.审核回复，设置上下文，并响应信息给用户，用户撤回提问时删除这一轮上下文，上下文中保存还原后的回复
+	reply, ok := g.moderateReply(reply)
+	if !ok {
+		return g.refuse()
+	}
+	msgID := g.msg.MsgId
+	quote, question := g.getQuestion()
+	g.service.SetUserSessionContext(msgID, buildQuoteRequest(quote, question), redactor.Restore(reply))
+	sent, err := replyText(g.msg, g.buildReplyText(restoreReply(redactor, true, reply)))
+	trackReply(g.msg, sent, func() { g.service.RemoveUserSessionContext(msgID) })
+	if err == errMessageRecalled {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("reply group error: %v ", err)
 	}
 
-	// 5.返回错误信息
+	// 9.返回错误信息
 	return err
 }
 
+// replySharedText 全群共享会话模式下回复，群里所有人的消息共用同一个上下文
+func (g *GroupMessageHandler) replySharedText() error {
+	// 1.获取提问，如果为空不处理
+	quote, question := g.getQuestion()
+	if quote == nil && question == "" {
+		requestLogger(g.msg).Info("group message is empty")
+		return nil
+	}
+
+	// 2.带上群上下文请求GPT，每条消息标注发送者，上下文和提问中的隐私信息替换为占位符
+	message := g.buildSharedMessage(buildQuoteRequest(quote, question))
+	redactor := newRedactor()
+	messages := redactMessages(redactor, append(g.groupService.GetGroupSessionContext(), message))
+	if knowledge.Enabled(g.group.NickName) {
+		request := &messages[len(messages)-1]
+		request.Content = knowledge.BuildPrompt(redactor.Redact(question), request.Content)
+	}
+	ctx, done := beginRequest(g.msg)
+	defer done()
+	reply, err := gpt.ChatCompletionsWithContext(ctx, messages)
+	if ctx.Err() != nil {
+		requestLogger(g.msg).Info("group message recalled, cancel reply")
+		return nil
+	}
+	if err != nil {
+		return g.replyError(err)
+	}
+
+	// 3.审核回复，设置群上下文，并响应信息给用户，用户撤回提问时删除这一轮上下文
+	reply, ok := g.moderateReply(reply)
+	if !ok {
+		return g.refuse()
+	}
+	msgID := g.msg.MsgId
+	g.groupService.AppendGroupSessionContext(msgID, message, gpt.Message{Role: "assistant", Content: redactor.Restore(reply)})
+	sent, err := replyText(g.msg, g.buildReplyText(restoreReply(redactor, true, reply)))
+	trackReply(g.msg, sent, func() { g.groupService.RemoveGroupSessionContext(msgID) })
+	if err == errMessageRecalled {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reply group error: %v ", err)
+	}
+	return nil
+}
+
+// moderateReply 审核回复内容
+func (g *GroupMessageHandler) moderateReply(reply string) (string, bool) {
+	return moderateReply(g.msg, g.group.NickName, g.senderName(), reply)
+}
+
+// refuse 内容审核不通过时回复提示
+func (g *GroupMessageHandler) refuse() error {
+	_, err := replyText(g.msg, "@"+g.sender.NickName+" "+config.LoadConfig().Moderation.RefuseText)
+	if err != nil && err != errMessageRecalled {
+		return fmt.Errorf("reply group error: %v", err)
+	}
+	return nil
+}
+
+// replyError 请求GPT出错时把错误回复给用户
+func (g *GroupMessageHandler) replyError(err error) error {
+	setAuditError(g.msg, err)
+	text := err.Error()
+	if strings.Contains(err.Error(), "context deadline exceeded") {
+		text = deadlineExceededText
+	}
+	_, err = replyText(g.msg, text)
+	if err != nil {
+		return fmt.Errorf("reply group error: %v", err)
+	}
+	return err
+}
+
+// senderName 获取发送者在群里的名称，没有设置群昵称时使用微信昵称
+func (g *GroupMessageHandler) senderName() string {
+	if g.sender.DisplayName != "" {
+		return g.sender.DisplayName
+	}
+	return g.sender.NickName
+}
+
+// buildSharedMessage 构建全群共享会话中的用户消息，标注发送者名称
+func (g *GroupMessageHandler) buildSharedMessage(text string) gpt.Message {
+	name := g.senderName()
+	return gpt.Message{
+		Role:    "user",
+		Name:    gpt.MessageName(name),
+		Content: name + "：" + text,
+	}
+}
+
 // getRequestText 获取请求接口的文本，要做一些清洗
 func (g *GroupMessageHandler) getRequestText() string {
 	// 1.去除空格以及换行
 	requestText := strings.TrimSpace(g.msg.Content)
 	requestText = strings.Trim(g.msg.Content, "\n")
 
-	// 2.替换掉当前用户名称
-	replaceText := "@" + g.self.NickName
-	requestText = strings.TrimSpace(strings.ReplaceAll(g.msg.Content, replaceText, ""))
-	if requestText == "" {
+	// 2.替换掉当前用户名称，如果引用了消息，把引用内容一起作为上下文
+	quote, question := g.getQuestion()
+	if quote == nil && question == "" {
 		return ""
 	}
+	requestText = buildQuoteRequest(quote, question)
 
 	// 3.获取上下文拼接在一起,如果字符长度超出4000截取为4000(GPT按字符长度算),达芬奇3最大为4068,也许后续为了适应要动态进行判断
 	sessionText := g.service.GetUserSessionContext()
@@ -167,6 +363,17 @@ func (g *GroupMessageHandler) getRequestText() string {
 	return requestText
 }
 
+// getQuestion 获取用户的提问，解析引用消息并去掉@机器人的文本以及唤醒词前缀
+func (g *GroupMessageHandler) getQuestion() (*quotedMessage, string) {
+	quote, text := parseQuote(strings.TrimSpace(g.msg.Content))
+	replaceText := "@" + g.self.NickName
+	text = strings.TrimSpace(strings.ReplaceAll(text, replaceText, ""))
+	if g.triggerPrefix != "" {
+		text = strings.TrimSpace(strings.TrimLeft(strings.TrimPrefix(text, g.triggerPrefix), ",，:："))
+	}
+	return quote, text
+}
+
 // buildReply 构建回复文本
 func (g *GroupMessageHandler) buildReplyText(reply string) string {
 	// 1.获取@我的用户
@@ -182,8 +389,10 @@ func (g *GroupMessageHandler) buildReplyText(reply string) string {
 	}
 
 	// 2.拼接回复, @我的用户, 问题, 回复
-	replaceText := "@" + g.self.NickName
-	question := strings.TrimSpace(strings.ReplaceAll(g.msg.Content, replaceText, ""))
+	quote, question := g.getQuestion()
+	if quote != nil && question == "" {
+		question = defaultQuoteQuestion
+	}
 	hr := strings.Repeat("-", 36)
 	reply = atText + "\n" + question + "\n" + hr + "\n" + reply
 	reply = strings.Trim(reply, "\n")
@@ -6,8 +6,8 @@ import (
 	"github.com/patrickmn/go-cache"
 	"github.com/qingconglaixueit/wechatbot/config"
 	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/pkg/metrics"
 	"github.com/skip2/go-qrcode"
-	"log"
 	"runtime"
 	"strings"
 	"time"
@@ -15,7 +15,8 @@ import (
 
 const deadlineExceededText = "请求GPT服务器超时[裂开]得不到回复，请重新发送问题[旺柴]"
 
-var c = cache.New(config.LoadConfig().SessionTimeout, time.Minute*5)
+// c 会话缓存，写入时按当前配置设置过期时间，修改session_timeout后新的会话立即生效
+var c = cache.New(cache.NoExpiration, time.Minute*5)
 
 // MessageHandlerInterface 消息处理接口
 type MessageHandlerInterface interface {
@@ -29,7 +30,7 @@ func QrCodeCallBack(uuid string) {
 		openwechat.PrintlnQrcodeUrl(uuid)
 	} else {
 		url := "https://login.weixin.qq.com/l/" + uuid
-		log.Println("如果二维码无法扫描，请缩小控制台尺寸，或更换命令行工具，缩小二维码像素。")
+		logger.Info("如果二维码无法扫描，请缩小控制台尺寸，或更换命令行工具，缩小二维码像素。")
 		q, _ := qrcode.New(url, qrcode.High)
 		fmt.Println(q.ToSmallString(true))
 	}
@@ -43,29 +44,49 @@ func NewHandler() (msgFunc func(msg *openwechat.Message), err error) {
 		return strings.Contains(message.Content, config.LoadConfig().SessionClearToken)
 	}, TokenMessageContextHandler())
 
-	// 处理群消息
+	// 新成员入群欢迎
 	dispatcher.RegisterHandler(func(message *openwechat.Message) bool {
-		return message.IsSendByGroup()
+		return IsJoinGroupMessage(message)
+	}, WelcomeMessageContextHandler())
+
+	// 处理群消息，群系统消息获取不到发送者，不处理
+	dispatcher.RegisterHandler(func(message *openwechat.Message) bool {
+		return message.IsSendByGroup() && !message.IsSystem()
 	}, GroupMessageContextHandler())
 
 	// 好友申请
 	dispatcher.RegisterHandler(func(message *openwechat.Message) bool {
 		return message.IsFriendAdd()
-	}, func(ctx *openwechat.MessageContext) {
-		msg := ctx.Message
-		if config.LoadConfig().AutoPass {
-			_, err := msg.Agree("")
-			if err != nil {
-				logger.Warning(fmt.Sprintf("add friend agree error : %v", err))
-				return
-			}
-		}
-	})
+	}, FriendAddMessageContextHandler())
 
 	// 私聊
 	// 获取用户消息处理器
 	dispatcher.RegisterHandler(func(message *openwechat.Message) bool {
 		return !(strings.Contains(message.Content, config.LoadConfig().SessionClearToken) || message.IsSendByGroup() || message.IsFriendAdd())
 	}, UserMessageContextHandler())
-	return openwechat.DispatchMessage(dispatcher), nil
+	// 消息放入队列由worker处理，不阻塞openwechat的消息接收
+	cfg := config.LoadConfig()
+	queue := newMessageQueue(cfg.Workers, cfg.QueueDepth, cfg.QueueSize, dispatcher.Dispatch)
+	metrics.QueueDepth.Set(func() float64 { return float64(queue.Len()) })
+	config.Subscribe(func(old, cfg *config.Configuration) {
+		queue.SetLimits(cfg.QueueDepth, cfg.QueueSize)
+		if old.Workers != cfg.Workers {
+			logger.Warning("config workers changed, restart to take effect")
+		}
+	})
+
+	// 重新登录后可能重复推送已经处理过的消息，去重后再入队，撤回消息不入队，避免排在被撤回的消息后面
+	return func(msg *openwechat.Message) {
+		if isDuplicateMessage(msg) {
+			return
+		}
+		setRequestID(msg)
+		beginAudit(msg)
+		recordReceived(msg)
+		if msg.IsRecalled() {
+			RecallMessageHandler(msg)
+			return
+		}
+		queue.Enqueue(msg)
+	}, nil
 }
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+)
+
+// requestIDKey 消息上下文中保存请求ID的key
+const requestIDKey = "request_id"
+
+// setRequestID 收到消息时生成请求ID，同一条消息的处理日志、GPT请求日志使用相同的请求ID
+func setRequestID(msg *openwechat.Message) {
+	msg.Set(requestIDKey, logger.NewRequestID())
+}
+
+// requestLogger 带请求ID和消息ID的日志
+func requestLogger(msg *openwechat.Message) *logger.Entry {
+	id, _ := msg.Get(requestIDKey)
+	return logger.With("request_id", id, "msg_id", msg.MsgId)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/patrickmn/go-cache"
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+// backlogKey 消息上下文中标记积压消息的key
+const backlogKey = "backlog"
+
+// handledMessages 已经处理过的消息ID，重新登录后微信可能重复推送同一条消息，过期时间每次按配置设置，配置更新后立即生效
+var handledMessages = cache.New(cache.NoExpiration, time.Minute*10)
+
+// isDuplicateMessage 消息是否已经处理过，没有处理过时记录消息ID，dedup_ttl为0时不去重
+func isDuplicateMessage(msg *openwechat.Message) bool {
+	id := msg.MsgId
+	ttl := time.Duration(config.LoadConfig().DedupTTL) * time.Second
+	// 过期时间为0时go-cache不会过期，不记录消息ID，避免内存一直增长
+	if id == "" || ttl <= 0 {
+		return false
+	}
+	// Add在key已存在时返回错误，判断和记录是原子操作
+	return handledMessages.Add(id, struct{}{}, ttl) != nil
+}
+
+// SaveHandledMessages 把没有过期的已处理消息ID和过期时间保存到文件，重启后加载，避免积压消息重复回复
+func SaveHandledMessages(path string) error {
+	// 消息ID对应的过期时间，unix纳秒
+	handled := make(map[string]int64)
+	for id, item := range handledMessages.Items() {
+		handled[id] = item.Expiration
+	}
+	data, err := json.Marshal(handled)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadHandledMessages 加载保存的已处理消息ID，已经过期的跳过，文件不存在时不处理
+func LoadHandledMessages(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	handled := make(map[string]int64)
+	if err = json.Unmarshal(data, &handled); err != nil {
+		return err
+	}
+	now := time.Now()
+	for id, expiration := range handled {
+		if ttl := time.Unix(0, expiration).Sub(now); ttl > 0 {
+			handledMessages.Set(id, struct{}{}, ttl)
+		}
+	}
+	return nil
+}
+
+// isStaleMessage 消息是否已经过时，过时的消息不回复
+// 超过stale_window的消息视为积压消息，开启answer_backlog时在backlog_window内的积压消息仍然回复，回复时加上致歉前缀
+func isStaleMessage(msg *openwechat.Message) bool {
+	cfg := config.LoadConfig()
+	age := time.Now().Unix() - msg.CreateTime
+	if age <= cfg.StaleWindow {
+		return false
+	}
+	if !cfg.AnswerBacklog || age > cfg.BacklogWindow {
+		return true
+	}
+	msg.Set(backlogKey, true)
+	return false
+}
+
+// replyText 按发送节奏回复消息，积压消息加上致歉前缀，超长的回复会分成多条发送，消息被撤回后停止发送
+func replyText(msg *openwechat.Message, text string) ([]*openwechat.SentMessage, error) {
+	if backlog, ok := msg.Get(backlogKey); ok && backlog.(bool) {
+		text = config.LoadConfig().BacklogPrefix + "\n" + text
+	}
+	// 每一段发送前检查消息是否已经被撤回
+	send := func(text string) (*openwechat.SentMessage, error) {
+		if IsRecalled(msg) {
+			return nil, errMessageRecalled
+		}
+		return msg.ReplyText(text)
+	}
+	sent, err := sendText(send, time.Unix(msg.CreateTime, 0), text)
+	writeAudit(msg, text, err)
+	return sent, err
+}
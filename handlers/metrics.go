@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/audit"
+	"github.com/qingconglaixueit/wechatbot/pkg/metrics"
+)
+
+// messageType 消息类型，用于指标的type标签
+func messageType(msg *openwechat.Message) string {
+	switch {
+	case msg.IsText():
+		return "text"
+	case msg.IsPicture():
+		return "picture"
+	case msg.IsEmoticon():
+		return "emoticon"
+	case msg.IsVoice():
+		return "voice"
+	case msg.IsVideo():
+		return "video"
+	case msg.IsRecalled():
+		return "recalled"
+	case msg.IsFriendAdd():
+		return "friend_add"
+	case msg.IsSystem():
+		return "system"
+	default:
+		return "other"
+	}
+}
+
+// recordReceived 记录收到的消息数和最后收到消息的时间
+func recordReceived(msg *openwechat.Message) {
+	chat := audit.ChatPrivate
+	if msg.IsComeFromGroup() {
+		chat = audit.ChatGroup
+	}
+	metrics.MessagesReceived.Inc(messageType(msg), chat)
+	metrics.LastReceived.Set(float64(time.Now().Unix()))
+}
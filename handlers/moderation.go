@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/moderation"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/rule"
+)
+
+// moderateInput 审核用户提问，mask时直接替换消息内容，返回false表示拒绝回复
+func moderateInput(msg *openwechat.Message, chat, sender string) bool {
+	cfg := config.LoadConfig().Moderation
+	masked, ok := moderate(msg.Bot, chat, sender, "提问", msg.Content, cfg.InputActions, moderation.Check, true)
+	msg.Content = masked
+	return ok
+}
+
+// moderateReply 审核GPT回复，返回处理后的回复，返回false表示拒绝发送
+func moderateReply(msg *openwechat.Message, chat, sender, reply string) (string, bool) {
+	return moderateOutput(msg.Bot, chat, sender, reply)
+}
+
+// moderateOutput 审核机器人主动发送的GPT内容，如定时群聊摘要、个性化欢迎语，返回false表示拒绝发送
+func moderateOutput(bot *openwechat.Bot, chat, sender, text string) (string, bool) {
+	cfg := config.LoadConfig().Moderation
+	return moderate(bot, chat, sender, "回复", text, cfg.OutputActions, moderation.Check, true)
+}
+
+// moderateRecord 审核记录下来用于生成群聊摘要的群消息，返回false表示不记录
+// 群里所有消息都会记录，只使用本地敏感词，不逐条调用审核接口，生成的摘要发送前由moderateOutput调用接口审核
+// 命中时不通知管理员，@机器人的提问由moderateInput通知
+func moderateRecord(chat, sender, text string) (string, bool) {
+	cfg := config.LoadConfig().Moderation
+	return moderate(nil, chat, sender, "群消息", text, cfg.InputActions, moderation.CheckWords, false)
+}
+
+// moderate 使用check审核文本并按配置的方式处理，warn为false时不通知管理员
+func moderate(bot *openwechat.Bot, chat, sender, kind, text string, actions []string,
+	check func(text string) *moderation.Result, warn bool) (string, bool) {
+	if len(actions) == 0 {
+		return text, true
+	}
+	result := check(text)
+	if !result.Hit() {
+		return text, true
+	}
+	logger.Warning(fmt.Sprintf("moderation hit %s, chat: %s, sender: %s, %s", kind, chat, sender, result.Reason()))
+
+	if warn && bot != nil && rule.Grule.InSlice(moderation.ActionWarn, actions) {
+		go warnAdmins(bot, fmt.Sprintf("[敏感内容提醒]\n会话：%s\n用户：%s\n类型：%s\n原因：%s\n内容：%s",
+			chat, sender, kind, result.Reason(), result.Masked))
+	}
+	if rule.Grule.InSlice(moderation.ActionRefuse, actions) {
+		return text, false
+	}
+	if rule.Grule.InSlice(moderation.ActionMask, actions) {
+		// 审核接口判定违规时无法定位敏感内容，只能拒绝
+		if result.Flagged {
+			return text, false
+		}
+		return result.Masked, true
+	}
+	return text, true
+}
+
+// warnAdmins 通知管理员
+func warnAdmins(bot *openwechat.Bot, text string) {
+	admins := config.LoadConfig().Moderation.Admins
+	if len(admins) == 0 {
+		return
+	}
+	self, err := bot.GetCurrentUser()
+	if err != nil {
+		logger.Warning(fmt.Sprintf("moderation get current user error: %v", err))
+		return
+	}
+	friends, err := self.Friends()
+	if err != nil {
+		logger.Warning(fmt.Sprintf("moderation get friends error: %v", err))
+		return
+	}
+	for _, name := range admins {
+		admin := friends.GetByRemarkName(name)
+		if admin == nil {
+			admin = friends.GetByNickName(name)
+		}
+		if admin == nil {
+			logger.Warning(fmt.Sprintf("moderation admin not found: %s", name))
+			continue
+		}
+		if _, err = sendText(admin.SendText, time.Now(), text); err != nil {
+			logger.Warning(fmt.Sprintf("moderation warn admin %s error: %v", name, err))
+		}
+	}
+}
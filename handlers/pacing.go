@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/pkg/metrics"
+)
+
+// pacer 发送节奏控制，模拟真人打字速度并限制账号的整体发送频率，降低被微信风控的概率
+type pacer struct {
+	lock sync.Mutex
+	// 最近一分钟内的发送时间
+	sent []time.Time
+}
+
+// sendPacer 当前账号的发送节奏，所有消息共用
+var sendPacer = &pacer{}
+
+// sendText 按发送节奏分段发送文本，start为开始打字的时间，打字时间从start开始计算
+func sendText(send func(text string) (*openwechat.SentMessage, error), start time.Time, text string) ([]*openwechat.SentMessage, error) {
+	parts := splitReply(text, config.LoadConfig().Pacing.SplitLength)
+	sentMessages := make([]*openwechat.SentMessage, 0, len(parts))
+	for _, part := range parts {
+		sendPacer.wait(start, part)
+		sentMessage, err := send(part)
+		if err != nil {
+			if err != errMessageRecalled {
+				metrics.Errors.Inc("send_error")
+			}
+			return sentMessages, err
+		}
+		sentMessages = append(sentMessages, sentMessage)
+		metrics.MessagesSent.Inc()
+		metrics.LastSent.Set(float64(time.Now().Unix()))
+		// 下一段从这一段发送完开始打字
+		start = time.Now()
+	}
+	return sentMessages, nil
+}
+
+// wait 等待打字时间，再等待账号发送频率低于限制
+func (p *pacer) wait(start time.Time, text string) {
+	cfg := config.LoadConfig().Pacing
+
+	// 1.打字时间，已经过去的时间（例如请求GPT的时间）不再重复等待
+	if delay := time.Until(start.Add(typingDelay(text, cfg))); delay > 0 {
+		time.Sleep(delay)
+	}
+
+	// 2.账号发送频率，持有锁等待，保证所有消息按顺序通过
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := time.Now()
+	for len(p.sent) > 0 && now.Sub(p.sent[0]) >= time.Minute {
+		p.sent = p.sent[1:]
+	}
+	if cfg.MaxPerMinute > 0 && len(p.sent) >= cfg.MaxPerMinute {
+		time.Sleep(time.Until(p.sent[len(p.sent)-cfg.MaxPerMinute].Add(time.Minute)))
+	}
+	if len(p.sent) > 0 {
+		interval := time.Duration(cfg.MinInterval) * time.Millisecond
+		if delay := time.Until(p.sent[len(p.sent)-1].Add(interval)); delay > 0 {
+			time.Sleep(delay)
+		}
+	}
+	p.sent = append(p.sent, time.Now())
+}
+
+// typingDelay 根据文本长度计算打字时间，加上随机抖动
+func typingDelay(text string, cfg config.PacingConfig) time.Duration {
+	delay := float64(cfg.MinDelay + cfg.PerCharDelay*len([]rune(text)))
+	if cfg.MaxDelay > 0 && delay > float64(cfg.MaxDelay) {
+		delay = float64(cfg.MaxDelay)
+	}
+	if cfg.Jitter > 0 {
+		random := rand.New(rand.NewSource(time.Now().UnixNano()))
+		delay *= 1 + cfg.Jitter*(2*random.Float64()-1)
+	}
+	return time.Duration(delay) * time.Millisecond
+}
+
+// splitReply 把超长的回复按段落切分为多条，每条不超过length个字符，length小于等于0时不切分
+// 每条去掉首尾的换行，只有空白字符的部分不发送，避免发出空消息
+func splitReply(text string, length int) []string {
+	parts := make([]string, 0)
+	add := func(part string) {
+		if part = strings.Trim(part, "\n"); strings.TrimSpace(part) != "" {
+			parts = append(parts, part)
+		}
+	}
+	if length <= 0 || len([]rune(text)) <= length {
+		add(text)
+		return parts
+	}
+	current := ""
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if current != "" && len([]rune(current+line)) > length {
+			add(current)
+			current = ""
+		}
+		runes := []rune(line)
+		for len(runes) > length {
+			add(string(runes[:length]))
+			runes = runes[length:]
+		}
+		current += string(runes)
+	}
+	add(current)
+	return parts
+}
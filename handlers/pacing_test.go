@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+func TestSplitReply(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		length int
+		want   []string
+	}{
+		{"no split", "第一段\n第二段", 0, []string{"第一段\n第二段"}},
+		{"short text", "你好", 10, []string{"你好"}},
+		{"split by paragraph", "第一段\n第二段\n第三段", 8, []string{"第一段\n第二段", "第三段"}},
+		{"long line split by length", "一二三四五六七", 3, []string{"一二三", "四五六", "七"}},
+		{"long line after paragraph", "ab\ncdefgh", 4, []string{"ab", "cdef", "gh"}},
+		{"empty text", "", 10, []string{}},
+		{"only newlines", "\n\n", 10, []string{}},
+		{"only newlines split", "\n\n\n\n\n", 2, []string{}},
+		{"blank lines between parts dropped", "第一段\n\n\n\n\n\n第二段", 4, []string{"第一段", "第二段"}},
+		{"whitespace only part dropped", "abcd\n   \n\nefgh", 4, []string{"abcd", "efgh"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := splitReply(test.text, test.length)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("splitReply(%q, %d) = %q, want %q", test.text, test.length, got, test.want)
+			}
+			for _, part := range got {
+				if test.length > 0 && len([]rune(part)) > test.length {
+					t.Errorf("part %q longer than %d", part, test.length)
+				}
+			}
+		})
+	}
+}
+
+func TestTypingDelay(t *testing.T) {
+	text := strings.Repeat("字", 10)
+	tests := []struct {
+		name     string
+		cfg      config.PacingConfig
+		min, max time.Duration
+	}{
+		{"disabled", config.PacingConfig{}, 0, 0},
+		{"min delay and per char", config.PacingConfig{MinDelay: 500, PerCharDelay: 100}, 1500 * time.Millisecond, 1500 * time.Millisecond},
+		{"capped by max delay", config.PacingConfig{MinDelay: 500, PerCharDelay: 100, MaxDelay: 1000}, time.Second, time.Second},
+		{"jitter", config.PacingConfig{MinDelay: 1000, Jitter: 0.2}, 800 * time.Millisecond, 1200 * time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				if delay := typingDelay(text, test.cfg); delay < test.min || delay > test.max {
+					t.Fatalf("typingDelay = %v, want between %v and %v", delay, test.min, test.max)
+				}
+			}
+		})
+	}
+}
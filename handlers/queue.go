@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/pkg/metrics"
+)
+
+// queueItem 排队中的消息
+type queueItem struct {
+	msg *openwechat.Message
+	// 是否需要机器人回复：私聊文本或者群里@机器人的文本
+	addressed bool
+}
+
+// noticeBufferSize 等待发送的排队提示数，超过时丢弃提示
+const noticeBufferSize = 100
+
+// queueNotice 排队提示
+type queueNotice struct {
+	msg  *openwechat.Message
+	text string
+}
+
+// messageQueue 消息队列，固定数量的worker处理消息，同一个会话的消息按顺序处理
+// 只有需要回复的消息受排队限制，其他消息只用于记录群聊摘要和共享会话上下文，处理很快，始终入队
+type messageQueue struct {
+	lock sync.Mutex
+	cond *sync.Cond
+	// 每个会话排队中的消息，key为会话
+	pending map[string][]queueItem
+	// 正在处理的会话，value为正在处理的消息是否需要回复
+	processing map[string]bool
+	// 等待处理的会话，每个会话最多出现一次
+	ready []string
+	// 所有会话排队中需要回复的消息总数
+	total int
+	// 每个会话最多排队的需要回复的消息数
+	depth int
+	// 所有会话最多排队的需要回复的消息数
+	size int
+	// 消息处理函数
+	handle func(msg *openwechat.Message)
+	// 等待发送的排队提示，由单独的goroutine按发送节奏发送，入队时不等待
+	notices chan queueNotice
+	// 发送排队提示
+	notify func(msg *openwechat.Message, text string)
+}
+
+// newMessageQueue 创建消息队列并启动worker
+func newMessageQueue(workers, depth, size int, handle func(msg *openwechat.Message)) *messageQueue {
+	if workers <= 0 {
+		workers = 1
+	}
+	q := &messageQueue{
+		pending:    make(map[string][]queueItem),
+		processing: make(map[string]bool),
+		depth:      depth,
+		size:       size,
+		handle:     handle,
+		notices:    make(chan queueNotice, noticeBufferSize),
+		notify:     replyNotice,
+	}
+	q.cond = sync.NewCond(&q.lock)
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	go q.sendNotices()
+	return q
+}
+
+// SetLimits 更新排队限制，配置热更新时调用，已经排队的消息不受影响
+func (q *messageQueue) SetLimits(depth, size int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.depth = depth
+	q.size = size
+}
+
+// Len 排队中等待回复的消息数，不包括正在处理的消息
+func (q *messageQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.total
+}
+
+// Enqueue 消息入队，需要回复的消息超过排队限制时拒绝，前面还有问题时提示排队
+func (q *messageQueue) Enqueue(msg *openwechat.Message) {
+	q.add(chatKey(msg), queueItem{msg: msg, addressed: msg.IsText() && (!msg.IsComeFromGroup() || msg.IsAt())})
+}
+
+// add 消息放入会话的队列
+func (q *messageQueue) add(key string, item queueItem) {
+	q.lock.Lock()
+	queue := q.pending[key]
+
+	// 统计前面还有几个需要回复的问题
+	queued := 0
+	for _, pending := range queue {
+		if pending.addressed {
+			queued++
+		}
+	}
+	ahead := queued
+	if addressed, ok := q.processing[key]; ok && addressed {
+		ahead++
+	}
+
+	if item.addressed {
+		if (q.depth > 0 && queued >= q.depth) || (q.size > 0 && q.total >= q.size) {
+			q.lock.Unlock()
+			metrics.Errors.Inc("queue_full")
+			logger.Warning(fmt.Sprintf("message queue full, drop message %s of chat %s", item.msg.MsgId, key))
+			q.reply(item.msg, "当前提问的人太多了，请稍后再试[旺柴]")
+			return
+		}
+		q.total++
+	}
+
+	q.pending[key] = append(queue, item)
+	if _, ok := q.processing[key]; !ok && len(queue) == 0 {
+		q.ready = append(q.ready, key)
+		q.cond.Signal()
+	}
+	q.lock.Unlock()
+
+	if item.addressed && ahead > 0 {
+		q.reply(item.msg, fmt.Sprintf("排队中, 前面还有%d个问题", ahead))
+	}
+}
+
+// work 循环取出等待处理的会话，每次处理一条消息，会话还有消息时重新排到末尾，避免单个会话占用worker
+func (q *messageQueue) work() {
+	for {
+		q.lock.Lock()
+		for len(q.ready) == 0 {
+			q.cond.Wait()
+		}
+		key := q.ready[0]
+		q.ready = q.ready[1:]
+		item := q.pending[key][0]
+		q.pending[key] = q.pending[key][1:]
+		if item.addressed {
+			q.total--
+		}
+		q.processing[key] = item.addressed
+		q.lock.Unlock()
+
+		q.safeHandle(item.msg)
+
+		q.lock.Lock()
+		delete(q.processing, key)
+		if len(q.pending[key]) > 0 {
+			q.ready = append(q.ready, key)
+			q.cond.Signal()
+		} else {
+			delete(q.pending, key)
+		}
+		q.lock.Unlock()
+	}
+}
+
+// safeHandle 处理消息，避免单条消息panic导致worker退出
+func (q *messageQueue) safeHandle(msg *openwechat.Message) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Danger(fmt.Sprintf("handle message %s panic: %v", msg.MsgId, err))
+		}
+	}()
+	q.handle(msg)
+}
+
+// reply 回复排队提示，Enqueue在openwechat接收消息的goroutine中调用，不能等待发送节奏，提示太多时直接丢弃
+func (q *messageQueue) reply(msg *openwechat.Message, text string) {
+	select {
+	case q.notices <- queueNotice{msg: msg, text: text}:
+	default:
+		logger.Warning(fmt.Sprintf("too many queue notices, drop notice of message %s", msg.MsgId))
+	}
+}
+
+// sendNotices 依次发送排队提示
+func (q *messageQueue) sendNotices() {
+	for notice := range q.notices {
+		q.notify(notice.msg, notice.text)
+	}
+}
+
+// replyNotice 按发送节奏回复排队提示
+func replyNotice(msg *openwechat.Message, text string) {
+	if _, err := sendText(msg.ReplyText, time.Now(), text); err != nil {
+		logger.Warning(fmt.Sprintf("reply queue notice error: %v", err))
+	}
+}
+
+// chatKey 消息所属的会话，群消息为群，私聊为对方
+func chatKey(msg *openwechat.Message) string {
+	if msg.IsSendBySelf() {
+		return msg.ToUserName
+	}
+	return msg.FromUserName
+}
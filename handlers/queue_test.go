@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eatmoreapple/openwechat"
+)
+
+// testQueue 创建测试用的消息队列，排队提示记录下来不发送
+func testQueue(workers, depth, size int, handle func(msg *openwechat.Message)) (*messageQueue, func() []string) {
+	var (
+		lock    sync.Mutex
+		notices []string
+	)
+	q := newMessageQueue(workers, depth, size, handle)
+	q.notify = func(msg *openwechat.Message, text string) {
+		lock.Lock()
+		defer lock.Unlock()
+		notices = append(notices, msg.MsgId+":"+text)
+	}
+	return q, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), notices...)
+	}
+}
+
+// waitFor 等待条件满足，超时时测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// rejectedIDs 排队提示中被拒绝的消息
+func rejectedIDs(notices []string) []string {
+	var ids []string
+	for _, notice := range notices {
+		if strings.HasSuffix(notice, ":当前提问的人太多了，请稍后再试[旺柴]") {
+			ids = append(ids, strings.SplitN(notice, ":", 2)[0])
+		}
+	}
+	return ids
+}
+
+func TestMessageQueueOrderPerChat(t *testing.T) {
+	var (
+		lock    sync.Mutex
+		handled = make(map[string][]int)
+		running = make(map[string]int)
+		overlap bool
+		count   int
+	)
+	q, _ := testQueue(4, 0, 0, func(msg *openwechat.Message) {
+		key := msg.FromUserName
+		lock.Lock()
+		running[key]++
+		if running[key] > 1 {
+			overlap = true
+		}
+		lock.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		lock.Lock()
+		running[key]--
+		n, _ := strconv.Atoi(msg.MsgId)
+		handled[key] = append(handled[key], n)
+		count++
+		lock.Unlock()
+	})
+
+	chats := []string{"a", "b", "c"}
+	const perChat = 20
+	for i := 0; i < perChat; i++ {
+		for _, chat := range chats {
+			msg := &openwechat.Message{MsgId: strconv.Itoa(i), FromUserName: chat}
+			q.add(chat, queueItem{msg: msg, addressed: i%2 == 0})
+		}
+	}
+	waitFor(t, "all messages handled", func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return count == perChat*len(chats)
+	})
+
+	lock.Lock()
+	defer lock.Unlock()
+	if overlap {
+		t.Error("messages of the same chat handled by two workers at once")
+	}
+	for _, chat := range chats {
+		for i, n := range handled[chat] {
+			if n != i {
+				t.Fatalf("chat %s handled out of order: %v", chat, handled[chat])
+			}
+		}
+	}
+}
+
+func TestMessageQueueLimits(t *testing.T) {
+	tests := []struct {
+		name        string
+		depth, size int
+		// 依次入队的消息所属的会话和消息，addressed为是否需要回复
+		keys  []string
+		items []queueItem
+		// 期望拒绝的消息
+		rejected []string
+	}{
+		{
+			name:  "reject at depth",
+			depth: 2,
+			keys:  []string{"a", "a", "a", "a"},
+			items: []queueItem{
+				{msg: &openwechat.Message{MsgId: "1"}, addressed: true},
+				{msg: &openwechat.Message{MsgId: "2"}, addressed: true},
+				{msg: &openwechat.Message{MsgId: "3"}, addressed: true},
+				{msg: &openwechat.Message{MsgId: "4"}, addressed: true},
+			},
+			rejected: []string{"4"},
+		},
+		{
+			name: "reject at size",
+			size: 2,
+			keys: []string{"a", "b", "c", "d"},
+			items: []queueItem{
+				{msg: &openwechat.Message{MsgId: "1"}, addressed: true},
+				{msg: &openwechat.Message{MsgId: "2"}, addressed: true},
+				{msg: &openwechat.Message{MsgId: "3"}, addressed: true},
+				{msg: &openwechat.Message{MsgId: "4"}, addressed: true},
+			},
+			rejected: []string{"4"},
+		},
+		{
+			name:  "messages without reply not limited",
+			depth: 1,
+			size:  1,
+			keys:  []string{"a", "a", "a", "a", "a"},
+			items: []queueItem{
+				{msg: &openwechat.Message{MsgId: "1"}, addressed: true},
+				{msg: &openwechat.Message{MsgId: "2"}},
+				{msg: &openwechat.Message{MsgId: "3"}, addressed: true},
+				{msg: &openwechat.Message{MsgId: "4"}},
+				{msg: &openwechat.Message{MsgId: "5"}, addressed: true},
+			},
+			rejected: []string{"5"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// 第一条消息处理时阻塞，后面的消息都在排队
+			release := make(chan struct{})
+			started := make(chan struct{}, 1)
+			var (
+				lock    sync.Mutex
+				handled []string
+			)
+			q, notices := testQueue(1, test.depth, test.size, func(msg *openwechat.Message) {
+				if msg.MsgId == "1" {
+					started <- struct{}{}
+					<-release
+				}
+				lock.Lock()
+				handled = append(handled, msg.MsgId)
+				lock.Unlock()
+			})
+
+			q.add(test.keys[0], test.items[0])
+			<-started
+			for i := 1; i < len(test.items); i++ {
+				q.add(test.keys[i], test.items[i])
+			}
+
+			waitFor(t, "rejection notices", func() bool { return len(rejectedIDs(notices())) == len(test.rejected) })
+			if got := rejectedIDs(notices()); !reflect.DeepEqual(got, test.rejected) {
+				t.Errorf("rejected = %v, want %v", got, test.rejected)
+			}
+			if q.Len() == 0 {
+				t.Error("expected queued messages")
+			}
+
+			// 处理完后排队数回到0，被拒绝的消息不处理
+			close(release)
+			waitFor(t, "queue drained", func() bool {
+				q.lock.Lock()
+				defer q.lock.Unlock()
+				return len(q.pending) == 0 && len(q.processing) == 0 && len(q.ready) == 0
+			})
+			if n := q.Len(); n != 0 {
+				t.Errorf("queue len after processing = %d, want 0", n)
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if len(handled) != len(test.items)-len(test.rejected) {
+				t.Errorf("handled = %v", handled)
+			}
+		})
+	}
+}
+
+func TestMessageQueueAheadNotice(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	q, notices := testQueue(1, 0, 0, func(msg *openwechat.Message) {
+		if msg.MsgId == "1" {
+			started <- struct{}{}
+			<-release
+		}
+	})
+	defer close(release)
+
+	q.add("a", queueItem{msg: &openwechat.Message{MsgId: "1"}, addressed: true})
+	<-started
+	q.add("a", queueItem{msg: &openwechat.Message{MsgId: "2"}})
+	q.add("a", queueItem{msg: &openwechat.Message{MsgId: "3"}, addressed: true})
+	waitFor(t, "ahead notice", func() bool { return len(notices()) == 1 })
+	if got := notices()[0]; got != "3:排队中, 前面还有1个问题" {
+		t.Errorf("notice = %q", got)
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"strings"
+)
+
+// quoteSeparator 微信引用消息中，引用内容与新消息之间的分隔线
+const quoteSeparator = "- - - - - - - - - - - - - - -"
+
+// defaultQuoteQuestion 只引用消息并@机器人、没有输入问题时的默认提问
+const defaultQuoteQuestion = "请解释一下这条消息"
+
+// quotedMessage 被引用的消息
+type quotedMessage struct {
+	// 被引用消息的作者
+	author string
+	// 被引用消息的内容
+	text string
+}
+
+// parseQuote 解析引用消息，返回被引用的消息以及新发送的文本，不是引用消息时返回nil和原文本
+// 微信引用消息格式为：「作者：被引用的内容」\n- - - - - - - - - - - - - - -\n新消息
+func parseQuote(content string) (*quotedMessage, string) {
+	index := strings.Index(content, quoteSeparator)
+	if index < 0 {
+		return nil, content
+	}
+
+	// 1.分隔线之前为引用块，必须被「」包裹
+	block := strings.TrimSpace(content[:index])
+	if !strings.HasPrefix(block, "「") || !strings.HasSuffix(block, "」") {
+		return nil, content
+	}
+	block = strings.TrimSuffix(strings.TrimPrefix(block, "「"), "」")
+
+	// 2.拆分作者与内容，作者与内容之间使用中文或英文冒号分隔
+	quote := &quotedMessage{text: block}
+	for _, sep := range []string{"：", ":"} {
+		if i := strings.Index(block, sep); i > 0 {
+			quote.author = strings.TrimSpace(block[:i])
+			quote.text = block[i+len(sep):]
+			break
+		}
+	}
+	quote.text = strings.TrimSpace(quote.text)
+
+	// 3.分隔线之后为新发送的文本
+	text := strings.TrimSpace(content[index+len(quoteSeparator):])
+	return quote, text
+}
+
+// buildQuoteRequest 将引用内容与问题拼接为结构化的请求文本
+func buildQuoteRequest(quote *quotedMessage, question string) string {
+	if quote == nil {
+		return question
+	}
+	if question == "" {
+		question = defaultQuoteQuestion
+	}
+
+	author := quote.author
+	if author == "" {
+		author = "未知用户"
+	}
+	return "引用的消息（来自" + author + "）：\n「" + quote.text + "」\n针对上面引用的消息提问：" + question
+}
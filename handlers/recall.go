@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/patrickmn/go-cache"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/service"
+)
+
+// errMessageRecalled 用户已经撤回了消息，不再回复
+var errMessageRecalled = errors.New("message recalled")
+
+// answeredMessage 已经回复的消息
+type answeredMessage struct {
+	// 机器人发送的回复
+	sent []*openwechat.SentMessage
+	// 从会话上下文中删除这一轮会话，没有写入上下文时为nil
+	remove func()
+}
+
+var (
+	// inflightRequests 正在请求GPT的消息，key为消息ID
+	inflightRequests = make(map[string]context.CancelFunc)
+	// recalledMessages 已经撤回的消息ID，撤回时消息可能还在排队
+	recalledMessages = cache.New(10*time.Minute, 10*time.Minute)
+	// answeredMessages 已经回复的消息，微信只能撤回2分钟内的消息
+	answeredMessages = cache.New(5*time.Minute, 10*time.Minute)
+	recallLock       sync.Mutex
+)
+
+// IsRecalled 消息是否已经被撤回
+func IsRecalled(msg *openwechat.Message) bool {
+	_, ok := recalledMessages.Get(msg.MsgId)
+	return ok
+}
+
+// beginRequest 开始为消息请求GPT，消息被撤回时ctx取消，请求结束后需要调用done
+func beginRequest(msg *openwechat.Message) (ctx context.Context, done func()) {
+	ctx = logger.NewContext(context.Background(), requestLogger(msg))
+	if usage := auditUsage(msg); usage != nil {
+		ctx = gpt.WithUsage(ctx, usage)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	recallLock.Lock()
+	defer recallLock.Unlock()
+	if _, ok := recalledMessages.Get(msg.MsgId); ok {
+		cancel()
+		return ctx, func() {}
+	}
+	inflightRequests[msg.MsgId] = cancel
+	return ctx, func() {
+		recallLock.Lock()
+		delete(inflightRequests, msg.MsgId)
+		recallLock.Unlock()
+		cancel()
+	}
+}
+
+// trackReply 记录消息的回复，消息在回复期间被撤回时立即删除会话并撤回回复
+func trackReply(msg *openwechat.Message, sent []*openwechat.SentMessage, remove func()) {
+	answered := &answeredMessage{sent: sent, remove: remove}
+	recallLock.Lock()
+	if _, ok := recalledMessages.Get(msg.MsgId); !ok {
+		answeredMessages.SetDefault(msg.MsgId, answered)
+		recallLock.Unlock()
+		return
+	}
+	recallLock.Unlock()
+	answered.undo()
+}
+
+// RecallMessageHandler 处理撤回消息：取消正在进行的GPT请求，从会话上下文和群消息记录中删除，按配置撤回机器人的回复
+func RecallMessageHandler(msg *openwechat.Message) {
+	revoke, err := msg.RevokeMsg()
+	if err != nil {
+		logger.Warning(fmt.Sprintf("parse revoke message error: %v", err))
+		return
+	}
+
+	ids := []string{strconv.FormatInt(revoke.RevokeMsg.MsgId, 10), strconv.FormatInt(revoke.RevokeMsg.OldMsgId, 10)}
+	answeredList := make([]*answeredMessage, 0)
+	recallLock.Lock()
+	for _, id := range ids {
+		if id == "0" {
+			continue
+		}
+		recalledMessages.SetDefault(id, struct{}{})
+		service.RemoveGroupMessage(id)
+		if cancel, ok := inflightRequests[id]; ok {
+			cancel()
+			delete(inflightRequests, id)
+		}
+		if answered, ok := answeredMessages.Get(id); ok {
+			answeredMessages.Delete(id)
+			answeredList = append(answeredList, answered.(*answeredMessage))
+		}
+	}
+	recallLock.Unlock()
+
+	for _, answered := range answeredList {
+		answered.undo()
+	}
+}
+
+// undo 删除会话上下文，按配置撤回回复
+func (a *answeredMessage) undo() {
+	if a.remove != nil {
+		a.remove()
+	}
+	if !config.LoadConfig().RecallReply {
+		return
+	}
+	for _, sent := range a.sent {
+		if sent == nil || !sent.CanRevoke() {
+			continue
+		}
+		if err := sent.Revoke(); err != nil {
+			logger.Warning(fmt.Sprintf("revoke reply error: %v", err))
+		}
+	}
+}
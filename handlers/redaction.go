@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/pkg/redact"
+)
+
+// newRedactor 创建一次请求使用的脱敏器，未启用脱敏时返回nil，nil脱敏器不做任何处理
+func newRedactor() *redact.Redactor {
+	if !config.LoadConfig().Redaction.Enable {
+		return nil
+	}
+	return redact.New()
+}
+
+// redactMessages 把上下文中的隐私信息替换为占位符，不修改原来的上下文
+func redactMessages(redactor *redact.Redactor, messages []gpt.Message) []gpt.Message {
+	redacted := make([]gpt.Message, 0, len(messages))
+	for _, message := range messages {
+		message.Content = redactor.Redact(message.Content)
+		redacted = append(redacted, message)
+	}
+	return redacted
+}
+
+// restoreReply 按配置把回复中的占位符还原为原文，群聊默认不还原，避免隐私信息被群里其他人看到
+func restoreReply(redactor *redact.Redactor, group bool, reply string) string {
+	cfg := config.LoadConfig().Redaction
+	if (group && !cfg.RestoreGroup) || (!group && !cfg.RestorePrivate) {
+		return reply
+	}
+	return redactor.Restore(reply)
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/service"
+)
+
+// SessionSnapshot 会话快照，export-sessions命令从快照文件导出
+type SessionSnapshot struct {
+	// 保存时间
+	SavedAt time.Time `json:"saved_at"`
+	// 没有过期的会话
+	Sessions []service.SessionRecord `json:"sessions"`
+}
+
+// SaveSessions 把当前的会话保存到快照文件，先写临时文件再重命名，避免导出时读到写了一半的文件
+func SaveSessions(path string) error {
+	snapshot := SessionSnapshot{SavedAt: time.Now(), Sessions: service.ExportSessions(c)}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// 会话中包含用户的聊天内容，只允许当前用户读写
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -5,8 +5,6 @@ import (
 	"github.com/eatmoreapple/openwechat"
 	"github.com/qingconglaixueit/wechatbot/pkg/logger"
 	"github.com/qingconglaixueit/wechatbot/service"
-	"math/rand"
-	"time"
 )
 
 var _ MessageHandlerInterface = (*TokenMessageHandler)(nil)
@@ -19,6 +17,8 @@ type TokenMessageHandler struct {
 	sender *openwechat.User
 	// 实现的用户业务
 	service service.UserServiceInterface
+	// 实现的群业务，只有群消息才有
+	groupService service.GroupServiceInterface
 }
 
 func TokenMessageContextHandler() func(ctx *openwechat.MessageContext) {
@@ -45,14 +45,21 @@ func NewTokenMessageHandler(msg *openwechat.Message) (MessageHandlerInterface, e
 	if err != nil {
 		return nil, err
 	}
+	var groupService service.GroupServiceInterface
+	chat := sender.NickName
 	if msg.IsComeFromGroup() {
+		groupService = service.NewGroupService(c, &openwechat.Group{User: sender})
 		sender, err = msg.SenderInGroup()
 	}
+	if sender != nil {
+		setAuditChat(msg, chat, sender.NickName)
+	}
 	userService := service.NewUserService(c, sender)
 	handler := &TokenMessageHandler{
-		msg:     msg,
-		sender:  sender,
-		service: userService,
+		msg:          msg,
+		sender:       sender,
+		service:      userService,
+		groupService: groupService,
 	}
 
 	return handler, nil
@@ -65,8 +72,6 @@ func (t *TokenMessageHandler) handle() error {
 
 // ReplyText 回复清空口令
 func (t *TokenMessageHandler) ReplyText() error {
-	maxInt := rand.New(rand.NewSource(time.Now().UnixNano())).Intn(5)
-	time.Sleep(time.Duration(maxInt+1) * time.Second)
 
 	t.service.ClearUserSessionContext()
 	var err error
@@ -74,10 +79,14 @@ func (t *TokenMessageHandler) ReplyText() error {
 		if !t.msg.IsAt() {
 			return err
 		}
+		// 全群共享会话模式下同时清空群上下文
+		if t.groupService.IsSharedSession() {
+			t.groupService.ClearGroupSessionContext()
+		}
 		atText := "@" + t.sender.NickName + "上下文已经清空，请问下个问题"
-		_, err = t.msg.ReplyText(atText)
+		_, err = replyText(t.msg, atText)
 	} else {
-		_, err = t.msg.ReplyText("上下文已经清空，请问下个问题")
+		_, err = replyText(t.msg, "上下文已经清空，请问下个问题")
 	}
 	return err
 }
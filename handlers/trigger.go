@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/rule"
+)
+
+// triggerPatterns 已编译的触发正则，key为正则表达式
+var triggerPatterns sync.Map
+
+// matchTrigger 匹配群聊触发规则，返回匹配到的规则以及唤醒词前缀（不是通过前缀触发时为空），没有匹配时返回nil
+func matchTrigger(groupName, text string) (*config.GroupTrigger, string) {
+	triggers := config.LoadConfig().GroupTriggers
+	for i := range triggers {
+		trigger := &triggers[i]
+		if len(trigger.Groups) > 0 && !rule.Grule.InSlice(groupName, trigger.Groups) {
+			continue
+		}
+
+		// 1.唤醒词前缀
+		if trigger.Prefix != "" && strings.HasPrefix(text, trigger.Prefix) {
+			return trigger, trigger.Prefix
+		}
+
+		// 2.正则匹配
+		if trigger.Pattern != "" {
+			pattern, err := compileTrigger(trigger.Pattern)
+			if err != nil {
+				logger.Warning(fmt.Sprintf("group trigger pattern error: %v", err))
+			} else if pattern.MatchString(text) {
+				return trigger, ""
+			}
+		}
+
+		// 3.随机插话
+		if trigger.Probability > 0 && rand.New(rand.NewSource(time.Now().UnixNano())).Float64() < trigger.Probability {
+			return trigger, ""
+		}
+	}
+	return nil, ""
+}
+
+// compileTrigger 编译触发正则并缓存
+func compileTrigger(expr string) (*regexp.Regexp, error) {
+	if pattern, ok := triggerPatterns.Load(expr); ok {
+		return pattern.(*regexp.Regexp), nil
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	triggerPatterns.Store(expr, pattern)
+	return pattern, nil
+}
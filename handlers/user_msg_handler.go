@@ -2,14 +2,14 @@ package handlers
 
 import (
 	"fmt"
-	"log"
-	"math/rand"
 	"strings"
 	"time"
 
 	"github.com/eatmoreapple/openwechat"
 	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/faq"
 	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/knowledge"
 	"github.com/qingconglaixueit/wechatbot/pkg/logger"
 	"github.com/qingconglaixueit/wechatbot/service"
 )
@@ -68,59 +68,111 @@ func (h *UserMessageHandler) handle() error {
 
 // ReplyText 发送文本消息到群
 func (h *UserMessageHandler) ReplyText() error {
-	if time.Now().Unix()-h.msg.CreateTime > 60 {
+	if isStaleMessage(h.msg) {
 		return nil
 	}
 
-	maxInt := rand.New(rand.NewSource(time.Now().UnixNano())).Intn(5)
-	time.Sleep(time.Duration(maxInt+1) * time.Second)
-
-	log.Printf("Received User[%v], Content[%v], CreateTime[%v]", h.sender.NickName, h.msg.Content,
-		time.Unix(h.msg.CreateTime, 0).Format("2006/01/02 15:04:05"))
+	requestLogger(h.msg).With("user", h.sender.NickName, "content", h.msg.Content,
+		"create_time", time.Unix(h.msg.CreateTime, 0).Format("2006/01/02 15:04:05")).Info("received user message")
+	setAuditChat(h.msg, h.sender.NickName, h.sender.NickName)
 
 	var (
 		reply string
 		err   error
 	)
-	// 1.获取上下文，如果字符串为空不处理
+	// 1.审核用户提问，拒绝时回复提示
+	if !moderateInput(h.msg, h.sender.NickName, h.sender.NickName) {
+		return h.refuse()
+	}
+
+	// 2.获取上下文，如果字符串为空不处理
 	requestText := h.getRequestText()
 	if requestText == "" {
-		log.Println("user message is empty")
+		requestLogger(h.msg).Info("user message is empty")
 		return nil
 	}
 
-	// 2.向GPT发起请求，如果回复文本等于空,不回复
-	reply, err = gpt.Completions(h.getRequestText())
+	// 3.匹配到FAQ的直接回复，不请求GPT
+	quote, question := parseQuote(strings.TrimSpace(h.msg.Content))
+	data := faq.Data{Name: h.sender.NickName, Question: question, Time: time.Now()}
+	if answer, ok := faq.Match(question, data); ok {
+		sent, err := replyText(h.msg, buildUserReply(answer))
+		trackReply(h.msg, sent, nil)
+		if err != nil && err != errMessageRecalled {
+			return fmt.Errorf("reply user error: %v ", err)
+		}
+		return nil
+	}
+
+	// 4.向GPT发起请求，如果回复文本等于空,不回复，提问中的隐私信息替换为占位符，私聊启用了知识库时带上检索到的资料
+	redactor := newRedactor()
+	prompt := redactor.Redact(requestText)
+	if knowledge.Enabled("") {
+		prompt = knowledge.BuildPrompt(redactor.Redact(question), prompt)
+	}
+	ctx, done := beginRequest(h.msg)
+	defer done()
+	reply, err = gpt.CompletionsWithContext(ctx, prompt)
+	if ctx.Err() != nil {
+		requestLogger(h.msg).Info("user message recalled, cancel reply")
+		return nil
+	}
 	if err != nil {
+		setAuditError(h.msg, err)
 		text := err.Error()
 		if strings.Contains(err.Error(), "context deadline exceeded") {
 			text = deadlineExceededText
 		}
-		_, err = h.msg.ReplyText(text)
+		_, err = replyText(h.msg, text)
 		if err != nil {
 			return fmt.Errorf("reply user error: %v ", err)
 		}
 		return err
 	}
 
-	// 2.设置上下文，回复用户
-	h.service.SetUserSessionContext(requestText, reply)
-	_, err = h.msg.ReplyText(buildUserReply(reply))
+	// 5.审核回复，设置上下文，回复用户，用户撤回提问时删除这一轮上下文
+	reply, ok := moderateReply(h.msg, h.sender.NickName, h.sender.NickName, reply)
+	if !ok {
+		return h.refuse()
+	}
+	msgID := h.msg.MsgId
+	h.service.SetUserSessionContext(msgID, buildQuoteRequest(quote, question), redactor.Restore(reply))
+	sent, err := replyText(h.msg, buildUserReply(restoreReply(redactor, false, reply)))
+	trackReply(h.msg, sent, func() { h.service.RemoveUserSessionContext(msgID) })
+	if err == errMessageRecalled {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("reply user error: %v ", err)
 	}
 
-	// 3.返回错误
+	// 6.返回错误
 	return err
 }
 
+// refuse 内容审核不通过时回复提示
+func (h *UserMessageHandler) refuse() error {
+	_, err := replyText(h.msg, config.LoadConfig().Moderation.RefuseText)
+	if err != nil && err != errMessageRecalled {
+		return fmt.Errorf("reply user error: %v ", err)
+	}
+	return nil
+}
+
 // getRequestText 获取请求接口的文本，要做一些清晰
 func (h *UserMessageHandler) getRequestText() string {
 	// 1.去除空格以及换行
 	requestText := strings.TrimSpace(h.msg.Content)
 	requestText = strings.Trim(h.msg.Content, "\n")
 
-	// 2.获取上下文，拼接在一起，如果字符长度超出4000，截取为4000。（GPT按字符长度算），达芬奇3最大为4068，也许后续为了适应要动态进行判断。
+	// 2.如果引用了消息，把引用内容一起作为上下文
+	quote, question := parseQuote(strings.TrimSpace(requestText))
+	if quote == nil && question == "" {
+		return ""
+	}
+	requestText = buildQuoteRequest(quote, question)
+
+	// 3.获取上下文，拼接在一起，如果字符长度超出4000，截取为4000。（GPT按字符长度算），达芬奇3最大为4068，也许后续为了适应要动态进行判断。
 	sessionText := h.service.GetUserSessionContext()
 	if sessionText != "" {
 		requestText = sessionText + "\n" + requestText
@@ -129,7 +181,7 @@ func (h *UserMessageHandler) getRequestText() string {
 		requestText = requestText[:4000]
 	}
 
-	// 3.检查用户发送文本是否包含结束标点符号
+	// 4.检查用户发送文本是否包含结束标点符号
 	punctuation := ",.;!?，。！？、…"
 	runeRequestText := []rune(requestText)
 	lastChar := string(runeRequestText[len(runeRequestText)-1:])
@@ -137,7 +189,7 @@ func (h *UserMessageHandler) getRequestText() string {
 		requestText = requestText + "？" // 判断最后字符是否加了标点，没有的话加上句号，避免openai自动补齐引起混乱。
 	}
 
-	// 4.返回请求文本
+	// 5.返回请求文本
 	return requestText
 }
 
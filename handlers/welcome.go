@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/rule"
+)
+
+// joinPatterns 新成员入群的系统消息，第一个分组为新成员名称，邀请多人时以顿号分隔
+var joinPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`邀请"(.+?)"加入了群聊`),
+	regexp.MustCompile(`"(.+?)"通过扫描.*二维码加入群聊`),
+}
+
+// pendingWelcome 等待发送的欢迎消息，key为群UserName
+var pendingWelcome = make(map[string][]string)
+var welcomeLock sync.Mutex
+
+// IsJoinGroupMessage 是否为新成员入群的系统消息，包括邀请入群和扫码入群
+func IsJoinGroupMessage(msg *openwechat.Message) bool {
+	return msg.IsSystem() && msg.IsSendByGroup() && len(parseJoinNames(msg.Content)) > 0
+}
+
+// WelcomeMessageContextHandler 新成员入群欢迎，同一个群短时间内多人入群只发送一条欢迎消息
+func WelcomeMessageContextHandler() func(ctx *openwechat.MessageContext) {
+	return func(ctx *openwechat.MessageContext) {
+		msg := ctx.Message
+		cfg := config.LoadConfig().Welcome
+		if cfg.Message == "" && !cfg.UseGPT {
+			return
+		}
+		sender, err := msg.Sender()
+		if err != nil {
+			logger.Warning(fmt.Sprintf("welcome get group error: %v", err))
+			return
+		}
+		group := &openwechat.Group{User: sender}
+		if len(cfg.Groups) > 0 && !rule.Grule.InSlice(group.NickName, cfg.Groups) {
+			return
+		}
+
+		// 第一个入群的成员开始计时，等待时间内入群的成员合并到同一条欢迎消息
+		welcomeLock.Lock()
+		defer welcomeLock.Unlock()
+		names, waiting := pendingWelcome[group.UserName]
+		pendingWelcome[group.UserName] = append(names, parseJoinNames(msg.Content)...)
+		if waiting {
+			return
+		}
+		time.AfterFunc(time.Duration(cfg.Delay)*time.Second, func() {
+			welcomeLock.Lock()
+			names := pendingWelcome[group.UserName]
+			delete(pendingWelcome, group.UserName)
+			welcomeLock.Unlock()
+			sendWelcome(group, names)
+		})
+	}
+}
+
+// parseJoinNames 从入群系统消息中解析新成员名称
+func parseJoinNames(content string) []string {
+	for _, pattern := range joinPatterns {
+		match := pattern.FindStringSubmatch(content)
+		if match == nil {
+			continue
+		}
+		names := make([]string, 0)
+		for _, name := range strings.Split(match[1], "、") {
+			if name = strings.Trim(name, `"`); name != "" {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// sendWelcome 发送欢迎消息，开启GPT时生成个性化欢迎语，失败时使用配置的欢迎语
+func sendWelcome(group *openwechat.Group, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	cfg := config.LoadConfig().Welcome
+	mentions := "@" + strings.Join(names, " @")
+
+	text := ""
+	if cfg.UseGPT {
+		prompt := fmt.Sprintf("请为刚加入微信群「%s」的新成员%s写一段简短热情的中文欢迎语，不超过80字，不要包含群规。",
+			group.NickName, strings.Join(names, "、"))
+		reply, err := gpt.Completions(prompt)
+		if err != nil {
+			logger.Warning(fmt.Sprintf("welcome gpt error: %v", err))
+		}
+		text = strings.TrimSpace(reply)
+		// GPT生成的欢迎语需要审核，拒绝时使用配置的欢迎语
+		if text != "" {
+			var bot *openwechat.Bot
+			if group.Self != nil {
+				bot = group.Self.Bot
+			}
+			if masked, ok := moderateOutput(bot, group.NickName, "欢迎语", text); ok {
+				text = masked
+			} else {
+				logger.Warning(fmt.Sprintf("welcome text of group %s refused by moderation", group.NickName))
+				text = ""
+			}
+		}
+	}
+	if text == "" {
+		var buf bytes.Buffer
+		tmpl, err := template.New("welcome").Parse(cfg.Message)
+		if err == nil {
+			err = tmpl.Execute(&buf, map[string]string{"Names": strings.Join(names, "、"), "Group": group.NickName})
+		}
+		if err != nil {
+			logger.Warning(fmt.Sprintf("welcome message template error: %v", err))
+			buf.Reset()
+			buf.WriteString(cfg.Message)
+		}
+		text = buf.String()
+	}
+	if text == "" {
+		return
+	}
+	if cfg.Rules != "" {
+		text += "\n" + strings.Repeat("-", 36) + "\n" + cfg.Rules
+	}
+
+	if _, err := sendText(group.SendText, time.Now(), mentions+"\n"+text); err != nil {
+		logger.Warning(fmt.Sprintf("welcome send to group %s error: %v", group.NickName, err))
+	}
+}
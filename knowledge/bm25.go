@@ -0,0 +1,117 @@
+package knowledge
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// BM25参数
+const (
+	bm25K1 = 1.5
+	bm25B  = 0.75
+)
+
+// bm25 本地BM25检索，不依赖网络，没有配置embeddings接口或接口不可用时使用
+type bm25 struct {
+	// 每个文档的词频
+	termFreqs []map[string]int
+	// 每个文档的词数
+	lengths []int
+	// 平均文档词数
+	avgLength float64
+	// 包含某个词的文档数
+	docFreqs map[string]int
+}
+
+// newBM25 为文档建立BM25索引
+func newBM25(docs []string) *bm25 {
+	b := &bm25{
+		termFreqs: make([]map[string]int, len(docs)),
+		lengths:   make([]int, len(docs)),
+		docFreqs:  make(map[string]int),
+	}
+	total := 0
+	for i, doc := range docs {
+		terms := tokenize(doc)
+		freqs := make(map[string]int)
+		for _, term := range terms {
+			freqs[term]++
+		}
+		for term := range freqs {
+			b.docFreqs[term]++
+		}
+		b.termFreqs[i] = freqs
+		b.lengths[i] = len(terms)
+		total += len(terms)
+	}
+	if len(docs) > 0 {
+		b.avgLength = float64(total) / float64(len(docs))
+	}
+	return b
+}
+
+// scores 计算查询与每个文档的BM25得分
+func (b *bm25) scores(query string) []float64 {
+	scores := make([]float64, len(b.termFreqs))
+	n := float64(len(b.termFreqs))
+	seen := make(map[string]bool)
+	for _, term := range tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		df := float64(b.docFreqs[term])
+		if df == 0 {
+			continue
+		}
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for i, freqs := range b.termFreqs {
+			tf := float64(freqs[term])
+			if tf == 0 {
+				continue
+			}
+			norm := 1 - bm25B + bm25B*float64(b.lengths[i])/b.avgLength
+			scores[i] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+	return scores
+}
+
+// tokenize 分词：英文和数字按单词切分并转为小写，中文等其他文字按相邻两个字切分
+func tokenize(text string) []string {
+	terms := make([]string, 0)
+	var word []rune
+	var han []rune
+	flushWord := func() {
+		if len(word) > 0 {
+			terms = append(terms, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	flushHan := func() {
+		if len(han) == 1 {
+			terms = append(terms, string(han))
+		}
+		for i := 0; i+1 < len(han); i++ {
+			terms = append(terms, string(han[i:i+2]))
+		}
+		han = han[:0]
+	}
+	for _, r := range text {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			flushHan()
+			word = append(word, r)
+		case unicode.IsLetter(r):
+			flushWord()
+			han = append(han, r)
+		default:
+			flushWord()
+			flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+	return terms
+}
@@ -0,0 +1,286 @@
+package knowledge
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/rule"
+)
+
+// embeddingBatchSize 每次请求embeddings接口的文本数量
+const embeddingBatchSize = 64
+
+// Chunk 知识库文档片段
+type Chunk struct {
+	// 来源文件，相对于知识库目录
+	File string `json:"file"`
+	// 片段内容
+	Text string `json:"text"`
+	// 片段内容的哈希，用于判断是否需要重新获取向量
+	Hash string `json:"hash"`
+	// 片段向量，未使用embeddings接口时为空
+	Vector []float64 `json:"vector,omitempty"`
+}
+
+// index 知识库索引
+type index struct {
+	chunks []*Chunk
+	bm25   *bm25
+}
+
+var (
+	current *index
+	lock    sync.RWMutex
+)
+
+// Enabled 群或私聊（group为空）是否启用了知识库
+func Enabled(group string) bool {
+	cfg := config.LoadConfig().Knowledge
+	if cfg.Dir == "" {
+		return false
+	}
+	if group == "" {
+		return cfg.Private
+	}
+	return rule.Grule.InSlice(group, cfg.Groups)
+}
+
+// Load 读取知识库目录中的Markdown/TXT文件，切分为片段并建立索引
+// 使用embeddings接口时，内容没有变化的片段复用磁盘上已保存的向量
+func Load() error {
+	root := config.LoadConfig()
+	cfg := root.Knowledge
+	if cfg.Dir == "" {
+		return nil
+	}
+
+	// 1.读取并切分文档
+	chunks, err := readChunks(cfg.Dir, cfg.ChunkSize)
+	if err != nil {
+		return err
+	}
+
+	// 2.获取向量，失败时只使用BM25检索
+	if cfg.Embedding {
+		if err = embedChunks(chunks, root.KnowledgeIndexPath(), cfg.EmbeddingModel); err != nil {
+			logger.Warning(fmt.Sprintf("knowledge embedding error, fallback to bm25: %v", err))
+			for _, chunk := range chunks {
+				chunk.Vector = nil
+			}
+		}
+	}
+
+	// 3.替换当前索引
+	texts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		texts[i] = chunk.Text
+	}
+	lock.Lock()
+	current = &index{chunks: chunks, bm25: newBM25(texts)}
+	lock.Unlock()
+	logger.Info(fmt.Sprintf("knowledge loaded %d chunks from %s", len(chunks), cfg.Dir))
+	return nil
+}
+
+// Search 检索与问题最相关的topK个片段，有向量时使用余弦相似度，否则使用BM25
+func Search(question string, topK int) []*Chunk {
+	lock.RLock()
+	idx := current
+	lock.RUnlock()
+	if idx == nil || len(idx.chunks) == 0 || topK <= 0 {
+		return nil
+	}
+
+	scores := make([]float64, len(idx.chunks))
+	usedVector := false
+	if idx.chunks[0].Vector != nil {
+		vectors, err := gpt.Embeddings(config.LoadConfig().Knowledge.EmbeddingModel, []string{question})
+		if err != nil {
+			logger.Warning(fmt.Sprintf("knowledge query embedding error, fallback to bm25: %v", err))
+		} else {
+			for i, chunk := range idx.chunks {
+				scores[i] = cosine(vectors[0], chunk.Vector)
+			}
+			usedVector = true
+		}
+	}
+	if !usedVector {
+		scores = idx.bm25.scores(question)
+	}
+
+	order := make([]int, len(idx.chunks))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+
+	results := make([]*Chunk, 0, topK)
+	for _, i := range order {
+		if len(results) >= topK || scores[i] <= 0 {
+			break
+		}
+		results = append(results, idx.chunks[i])
+	}
+	return results
+}
+
+// BuildPrompt 检索知识库并把相关片段拼接在请求文本前面，没有检索到时返回原文本
+func BuildPrompt(question, requestText string) string {
+	chunks := Search(question, config.LoadConfig().Knowledge.TopK)
+	if len(chunks) == 0 {
+		return requestText
+	}
+
+	var builder strings.Builder
+	builder.WriteString("请优先根据以下资料回答问题，资料中没有相关内容时请如实说明：\n")
+	for i, chunk := range chunks {
+		builder.WriteString(fmt.Sprintf("[%d]《%s》\n%s\n", i+1, chunk.File, chunk.Text))
+	}
+	builder.WriteString("\n")
+	builder.WriteString(requestText)
+	return builder.String()
+}
+
+// readChunks 遍历目录读取文档并切分为片段
+func readChunks(dir string, size int) ([]*Chunk, error) {
+	chunks := make([]*Chunk, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if info.IsDir() || (ext != ".md" && ext != ".markdown" && ext != ".txt") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			name = path
+		}
+		for _, text := range splitText(string(data), size) {
+			sum := sha1.Sum([]byte(text))
+			chunks = append(chunks, &Chunk{File: name, Text: text, Hash: hex.EncodeToString(sum[:])})
+		}
+		return nil
+	})
+	return chunks, err
+}
+
+// splitText 按段落切分文本，每个片段不超过size个字符，超长的段落按字符强制切分
+func splitText(text string, size int) []string {
+	if size <= 0 {
+		size = 500
+	}
+	paragraphs := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n")
+	chunks := make([]string, 0)
+	current := make([]rune, 0, size)
+	flush := func() {
+		if chunk := strings.TrimSpace(string(current)); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		current = current[:0]
+	}
+	for _, paragraph := range paragraphs {
+		runes := []rune(strings.TrimSpace(paragraph))
+		if len(runes) == 0 {
+			continue
+		}
+		if len(current)+len(runes) > size {
+			flush()
+		}
+		for len(runes) > size {
+			chunks = append(chunks, string(runes[:size]))
+			runes = runes[size:]
+		}
+		if len(current) > 0 {
+			current = append(current, '\n', '\n')
+		}
+		current = append(current, runes...)
+	}
+	flush()
+	return chunks
+}
+
+// embedChunks 获取片段向量，优先复用索引文件中内容相同的片段向量，完成后保存索引文件
+func embedChunks(chunks []*Chunk, indexFile, model string) error {
+	saved := make(map[string][]float64)
+	if data, err := ioutil.ReadFile(indexFile); err == nil {
+		var old []*Chunk
+		if err = json.Unmarshal(data, &old); err != nil {
+			logger.Warning(fmt.Sprintf("knowledge read index %s error: %v", indexFile, err))
+		}
+		for _, chunk := range old {
+			if chunk.Vector != nil {
+				saved[chunk.Hash] = chunk.Vector
+			}
+		}
+	}
+
+	pending := make([]*Chunk, 0)
+	for _, chunk := range chunks {
+		if vector, ok := saved[chunk.Hash]; ok {
+			chunk.Vector = vector
+		} else {
+			pending = append(pending, chunk)
+		}
+	}
+	for start := 0; start < len(pending); start += embeddingBatchSize {
+		end := start + embeddingBatchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		texts := make([]string, 0, end-start)
+		for _, chunk := range pending[start:end] {
+			texts = append(texts, chunk.Text)
+		}
+		vectors, err := gpt.Embeddings(model, texts)
+		if err != nil {
+			return err
+		}
+		for i, chunk := range pending[start:end] {
+			chunk.Vector = vectors[i]
+		}
+	}
+
+	data, err := json.Marshal(chunks)
+	if err != nil {
+		return err
+	}
+	// 启动时知识库在登录之前加载，数据目录可能还没有创建
+	if err = os.MkdirAll(filepath.Dir(indexFile), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(indexFile, data, 0644)
+}
+
+// cosine 余弦相似度
+func cosine(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -1,9 +1,120 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/qingconglaixueit/wechatbot/bootstrap"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
 )
 
+// version 版本号，构建时通过 -ldflags "-X main.version=v1.0.0" 设置
+var version = "dev"
+
+// commands 子命令及说明
+var commands = [][2]string{
+	{"run", "登录微信并开始处理消息，不指定子命令时默认执行"},
+	{"check-config", "检查配置并打印所有问题"},
+	{"print-config", "打印合并后生效的配置，api_key等敏感配置只显示最后几位"},
+	{"logout", "退出微信登录并删除登录状态文件"},
+	{"export-sessions", "导出最近一次保存的会话快照"},
+	{"usage-report", "按天和模型统计GPT请求次数和token用量"},
+	{"export-audit", "按日期、群、用户导出审计日志"},
+	{"version", "打印版本号"},
+}
+
+// stringList 可以重复传入的命令行参数
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func main() {
-	bootstrap.Run()
+	// 1.解析子命令，第一个参数不是子命令时执行run，兼容直接传参数的启动方式
+	command := "run"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	// 2.所有子命令共用的参数，命令行参数优先级最高，覆盖配置文件和环境变量
+	var overrides stringList
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	configFile := flags.String("config", "", "配置文件，支持json、yaml、toml，默认依次查找config.json、config.yaml、config.yml、config.toml")
+	storage := flags.String("storage", "", "登录状态文件，默认为数据目录下的storage.json")
+	dataDir := flags.String("data-dir", "", "数据目录，保存登录状态、会话快照、用量记录等文件，默认为当前目录")
+	logLevel := flags.String("log-level", "", "日志级别：debug、info、warning、error")
+	flags.Var(&overrides, "set", "覆盖配置，格式为key.path=value，可以重复传入，如 --set max_tokens=1024 --set pacing.jitter=0.5")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "用法: %s [子命令] [参数]\n\n子命令:\n", os.Args[0])
+		for _, c := range commands {
+			fmt.Fprintf(flags.Output(), "  %-16s %s\n", c[0], c[1])
+		}
+		fmt.Fprintf(flags.Output(), "\n%s 参数:\n", command)
+		flags.PrintDefaults()
+	}
+
+	// 3.子命令自己的参数
+	checkConfig := flags.Bool("check-config", false, "同check-config子命令")
+	printConfig := flags.Bool("print-config", false, "同print-config子命令")
+	output := flags.String("output", "", "export-sessions、export-audit导出到的文件，默认输出到标准输出")
+	format := flags.String("format", "", "导出格式，export-sessions为json（默认）、text，export-audit为jsonl（默认）、csv")
+	days := flags.Int("days", 7, "usage-report统计最近几天")
+	since := flags.String("since", "", "export-audit导出这一天及以后的记录，如2023-03-01")
+	until := flags.String("until", "", "export-audit导出这一天及以前的记录，如2023-03-31")
+	group := flags.String("group", "", "export-audit只导出这个群的记录")
+	user := flags.String("user", "", "export-audit只导出这个用户发送的记录")
+	_ = flags.Parse(args)
+
+	config.SetFile(*configFile)
+	for name, value := range map[string]string{"storage_file": *storage, "data_dir": *dataDir, "log_level": *logLevel} {
+		if value != "" {
+			overrides = append([]string{name + "=" + value}, overrides...)
+		}
+	}
+	config.SetOverrides(overrides)
+
+	// 4.执行子命令
+	switch {
+	case command == "check-config" || *checkConfig:
+		os.Exit(bootstrap.CheckConfig())
+	case command == "print-config" || *printConfig:
+		os.Exit(bootstrap.PrintConfig())
+	case command == "logout":
+		os.Exit(bootstrap.Logout())
+	case command == "export-sessions":
+		os.Exit(bootstrap.ExportSessions(*output, defaultString(*format, "json")))
+	case command == "export-audit":
+		os.Exit(bootstrap.ExportAudit(*since, *until, *group, *user, *output, defaultString(*format, "jsonl")))
+	case command == "usage-report":
+		os.Exit(bootstrap.UsageReport(*days))
+	case command == "version":
+		fmt.Println(version)
+	case command == "run":
+		if err := bootstrap.Run(); err != nil {
+			logger.Danger(err.Error())
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", command)
+		flags.Usage()
+		os.Exit(2)
+	}
+}
+
+// defaultString value为空时返回默认值
+func defaultString(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
 }
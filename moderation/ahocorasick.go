@@ -0,0 +1,112 @@
+package moderation
+
+import (
+	"unicode"
+)
+
+// acNode Aho-Corasick自动机节点
+type acNode struct {
+	children map[rune]*acNode
+	fail     *acNode
+	// 以该节点结尾的敏感词长度（字符数），不是词尾时为0
+	length int
+	// 以该节点结尾的敏感词
+	word string
+}
+
+// matcher Aho-Corasick多模式匹配，一次扫描找出文本中的所有敏感词，忽略大小写
+type matcher struct {
+	root *acNode
+}
+
+// match 敏感词在文本中的位置，start和end为字符下标
+type match struct {
+	word       string
+	start, end int
+}
+
+// newMatcher 根据敏感词构建自动机
+func newMatcher(words []string) *matcher {
+	root := &acNode{children: make(map[rune]*acNode)}
+	for _, word := range words {
+		runes := []rune(word)
+		if len(runes) == 0 {
+			continue
+		}
+		node := root
+		for _, r := range runes {
+			r = unicode.ToLower(r)
+			child, ok := node.children[r]
+			if !ok {
+				child = &acNode{children: make(map[rune]*acNode)}
+				node.children[r] = child
+			}
+			node = child
+		}
+		node.length = len(runes)
+		node.word = word
+	}
+
+	// 广度优先构建失败指针
+	queue := make([]*acNode, 0)
+	for _, child := range root.children {
+		child.fail = root
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for r, child := range node.children {
+			fail := node.fail
+			for fail != nil && fail.children[r] == nil {
+				fail = fail.fail
+			}
+			if fail == nil {
+				child.fail = root
+			} else {
+				child.fail = fail.children[r]
+			}
+			queue = append(queue, child)
+		}
+	}
+	return &matcher{root: root}
+}
+
+// find 找出文本中出现的所有敏感词
+func (m *matcher) find(runes []rune) []match {
+	matches := make([]match, 0)
+	node := m.root
+	for i, r := range runes {
+		r = unicode.ToLower(r)
+		for node != m.root && node.children[r] == nil {
+			node = node.fail
+		}
+		if next, ok := node.children[r]; ok {
+			node = next
+		}
+		for out := node; out != m.root && out != nil; out = out.fail {
+			if out.length > 0 {
+				matches = append(matches, match{word: out.word, start: i - out.length + 1, end: i + 1})
+			}
+		}
+	}
+	return matches
+}
+
+// check 找出文本中的敏感词，并把敏感词替换为*
+func (m *matcher) check(text string) *Result {
+	result := &Result{}
+	runes := []rune(text)
+	seen := make(map[string]bool)
+	for _, hit := range m.find(runes) {
+		if !seen[hit.word] {
+			seen[hit.word] = true
+			result.Words = append(result.Words, hit.word)
+		}
+		for i := hit.start; i < hit.end; i++ {
+			runes[i] = '*'
+		}
+	}
+	result.Masked = string(runes)
+	return result
+}
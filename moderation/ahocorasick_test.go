@@ -0,0 +1,38 @@
+package moderation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatcherCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		words  []string
+		text   string
+		want   []string
+		masked string
+	}{
+		{"no hit", []string{"赌博"}, "今天天气不错", nil, "今天天气不错"},
+		{"multi-byte", []string{"赌博"}, "禁止赌博！", []string{"赌博"}, "禁止**！"},
+		{"repeated word reported once", []string{"赌博"}, "赌博赌博", []string{"赌博"}, "****"},
+		{"overlapping words", []string{"he", "she", "hers"}, "ushers", []string{"she", "he", "hers"}, "u*****"},
+		{"word inside another word", []string{"法轮", "法轮功"}, "练法轮功", []string{"法轮", "法轮功"}, "练***"},
+		{"failure link across words", []string{"abcd", "bce"}, "abce", []string{"bce"}, "a***"},
+		{"case folding", []string{"Spam"}, "no SPAM or spam", []string{"Spam"}, "no **** or ****"},
+		{"mixed scripts", []string{"VPN翻墙"}, "教你vpn翻墙。", []string{"VPN翻墙"}, "教你*****。"},
+		{"emoji", []string{"🔫"}, "买🔫吗", []string{"🔫"}, "买*吗"},
+		{"empty word ignored", []string{"", "坏"}, "好坏", []string{"坏"}, "好*"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := newMatcher(test.words).check(test.text)
+			if !reflect.DeepEqual(result.Words, test.want) {
+				t.Errorf("words = %v, want %v", result.Words, test.want)
+			}
+			if result.Masked != test.masked {
+				t.Errorf("masked = %q, want %q", result.Masked, test.masked)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package moderation
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/pkg/filewatch"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/pkg/redact"
+)
+
+const (
+	// ActionRefuse 拒绝回复
+	ActionRefuse = "refuse"
+	// ActionMask 把敏感词替换为*，审核接口判定违规时无法替换，按拒绝处理
+	ActionMask = "mask"
+	// ActionWarn 通知管理员
+	ActionWarn = "warn"
+)
+
+// reloadInterval 检查敏感词文件是否修改的间隔
+const reloadInterval = 3 * time.Second
+
+// Result 审核结果
+type Result struct {
+	// 命中的敏感词
+	Words []string
+	// 审核接口是否判定违规
+	Flagged bool
+	// 审核接口判定违规的类别
+	Categories []string
+	// 敏感词替换为*之后的文本
+	Masked string
+}
+
+// Hit 是否命中敏感词或被审核接口判定违规
+func (r *Result) Hit() bool {
+	return len(r.Words) > 0 || r.Flagged
+}
+
+// Reason 命中原因，用于通知管理员
+func (r *Result) Reason() string {
+	reasons := make([]string, 0, 2)
+	if len(r.Words) > 0 {
+		reasons = append(reasons, "敏感词："+strings.Join(r.Words, "、"))
+	}
+	if r.Flagged {
+		reasons = append(reasons, "审核接口："+strings.Join(r.Categories, "、"))
+	}
+	return strings.Join(reasons, "；")
+}
+
+// wordsFile 敏感词文件，修改后自动重新加载，加载失败时继续使用原来的敏感词
+var wordsFile = filewatch.New("moderation", reloadInterval, func(path string) (interface{}, error) {
+	words, err := readWords(path)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info(fmt.Sprintf("moderation loaded %d words from %s", len(words), path))
+	return newMatcher(words), nil
+})
+
+// CheckWords 只使用本地敏感词审核文本，不调用审核接口，用于群里每条消息都要审核的场景
+func CheckWords(text string) *Result {
+	if m := loadMatcher(); m != nil {
+		return m.check(text)
+	}
+	return &Result{Masked: text}
+}
+
+// Check 审核文本：先使用本地敏感词匹配，配置了审核接口时再调用接口，接口出错时只使用本地结果
+func Check(text string) *Result {
+	result := CheckWords(text)
+	if cfg := config.LoadConfig(); cfg.Moderation.Endpoint {
+		// 启用了脱敏时，手机号、身份证号等隐私信息替换为占位符后再发送给审核接口
+		if cfg.Redaction.Enable {
+			text = redact.New().Redact(text)
+		}
+		flagged, categories, err := gpt.Moderation(text)
+		if err != nil {
+			logger.Warning(fmt.Sprintf("moderation endpoint error: %v", err))
+		} else {
+			result.Flagged, result.Categories = flagged, categories
+		}
+	}
+	return result
+}
+
+// loadMatcher 获取敏感词自动机，没有配置敏感词文件时返回nil
+func loadMatcher() *matcher {
+	m, _ := wordsFile.Get(config.LoadConfig().Moderation.WordsFile).(*matcher)
+	return m
+}
+
+// readWords 读取敏感词文件，每行一个敏感词，忽略空行和#开头的注释
+func readWords(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	words := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		words = append(words, word)
+	}
+	return words, scanner.Err()
+}
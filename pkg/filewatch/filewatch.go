@@ -0,0 +1,78 @@
+package filewatch
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+)
+
+// Fingerprint 文件的路径和修改时间，新增、删除、修改任意一个文件都会变化
+func Fingerprint(paths ...string) string {
+	var builder strings.Builder
+	for _, path := range paths {
+		builder.WriteString(path)
+		if info, err := os.Stat(path); err == nil {
+			builder.WriteString(info.ModTime().String())
+		}
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// File 修改后自动重新加载的文件，如FAQ规则、敏感词，使用时才检查文件是否修改，不需要单独的goroutine
+type File struct {
+	// 日志中的名称
+	name string
+	// 检查文件是否修改的间隔
+	interval time.Duration
+	// 读取并解析文件
+	load func(path string) (interface{}, error)
+
+	lock      sync.Mutex
+	value     interface{}
+	path      string
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// New 创建自动重新加载的文件，load读取并解析文件，返回的值由Get返回
+func New(name string, interval time.Duration, load func(path string) (interface{}, error)) *File {
+	return &File{name: name, interval: interval, load: load}
+}
+
+// Get 获取文件解析后的值，距离上次检查超过间隔时检查文件，路径或者修改时间变化后重新加载
+// 文件不存在或者加载失败时继续使用原来的值，从来没有加载成功时返回nil
+func (f *File) Get(path string) interface{} {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if path == f.path && time.Since(f.lastCheck) < f.interval {
+		return f.value
+	}
+	f.lastCheck = time.Now()
+
+	if path == "" {
+		return f.value
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warning(fmt.Sprintf("%s stat %s error: %v", f.name, path, err))
+		}
+		return f.value
+	}
+	if path == f.path && info.ModTime().Equal(f.modTime) {
+		return f.value
+	}
+
+	value, err := f.load(path)
+	if err != nil {
+		logger.Warning(fmt.Sprintf("%s load %s error: %v", f.name, path, err))
+		return f.value
+	}
+	f.value, f.path, f.modTime = value, path, info.ModTime()
+	return f.value
+}
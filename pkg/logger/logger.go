@@ -1,40 +1,319 @@
 package logger
 
 import (
-	"log"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
-var Logger *log.Logger
-var once sync.Once
+// 日志级别，低于当前级别的日志不输出
+const (
+	LevelDebug int32 = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+// 日志格式
+const (
+	FormatText int32 = iota
+	FormatJSON
+)
+
+// levelNames 日志级别名称
+var levelNames = map[int32]string{
+	LevelDebug:   "DEBUG",
+	LevelInfo:    "INFO",
+	LevelWarning: "WARNING",
+	LevelError:   "ERROR",
+}
+
+// contentFields 消息内容相关的字段，默认只记录长度
+var contentFields = map[string]bool{
+	"content":  true,
+	"question": true,
+	"reply":    true,
+	"prompt":   true,
+	"text":     true,
+}
+
+// keyPatterns 日志中自动隐藏的密钥
+var keyPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`sk-[A-Za-z0-9_\-]{8,}`),
+	regexp.MustCompile(`Bearer\s+\S+`),
+}
+
+var (
+	output    io.Writer = os.Stdout
+	writeLock sync.Mutex
+	// level 当前日志级别
+	level = LevelInfo
+	// format 当前日志格式
+	format = FormatText
+	// showContent 是否记录消息内容，1为记录
+	showContent int32
+	// secrets 需要隐藏的密钥原文
+	secrets atomic.Value
+)
+
+// Fields 日志字段
+type Fields map[string]interface{}
+
+// Entry 带字段的日志，With返回新的Entry，不修改原来的字段，可以在多个goroutine中使用
+type Entry struct {
+	fields Fields
+}
+
+// std 没有字段的日志
+var std = &Entry{}
+
+// SetLevel 设置日志级别：debug、info、warning、error
+func SetLevel(name string) error {
+	switch strings.ToLower(name) {
+	case "debug":
+		atomic.StoreInt32(&level, LevelDebug)
+	case "info":
+		atomic.StoreInt32(&level, LevelInfo)
+	case "warning", "warn":
+		atomic.StoreInt32(&level, LevelWarning)
+	case "error":
+		atomic.StoreInt32(&level, LevelError)
+	default:
+		return fmt.Errorf("unknown log level %q", name)
+	}
+	return nil
+}
+
+// SetFormat 设置日志格式：text、json
+func SetFormat(name string) error {
+	switch strings.ToLower(name) {
+	case "text", "":
+		atomic.StoreInt32(&format, FormatText)
+	case "json":
+		atomic.StoreInt32(&format, FormatJSON)
+	default:
+		return fmt.Errorf("unknown log format %q", name)
+	}
+	return nil
+}
+
+// SetContent 设置是否记录消息内容，不记录时content、question、reply等字段只记录长度
+func SetContent(show bool) {
+	var value int32
+	if show {
+		value = 1
+	}
+	atomic.StoreInt32(&showContent, value)
+}
+
+// SetSecrets 设置需要在日志中隐藏的密钥，如api_key
+func SetSecrets(values ...string) {
+	list := make([]string, 0, len(values))
+	for _, value := range values {
+		if len(value) >= 4 {
+			list = append(list, value)
+		}
+	}
+	secrets.Store(list)
+}
+
+// SetOutput 设置日志输出
+func SetOutput(w io.Writer) {
+	writeLock.Lock()
+	defer writeLock.Unlock()
+	output = w
+}
+
+// With 创建带字段的日志，参数为key、value交替
+func With(keyvals ...interface{}) *Entry {
+	return std.With(keyvals...)
+}
+
+// With 在当前字段的基础上增加字段
+func (e *Entry) With(keyvals ...interface{}) *Entry {
+	fields := make(Fields, len(e.fields)+len(keyvals)/2)
+	for key, value := range e.fields {
+		fields[key] = value
+	}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		fields[fmt.Sprint(keyvals[i])] = keyvals[i+1]
+	}
+	return &Entry{fields: fields}
+}
+
+// contextKey 上下文中保存日志的key
+type contextKey struct{}
+
+// NewContext 把日志放入上下文，同一个请求的日志带上相同的请求ID
+func NewContext(ctx context.Context, e *Entry) context.Context {
+	return context.WithValue(ctx, contextKey{}, e)
+}
 
-func init() {
-	once.Do(func() {
-		Logger = log.New(os.Stdout, "INFO", log.LstdFlags|log.Llongfile)
-	})
+// FromContext 获取上下文中的日志，没有时返回不带字段的日志
+func FromContext(ctx context.Context) *Entry {
+	if e, ok := ctx.Value(contextKey{}).(*Entry); ok {
+		return e
+	}
+	return std
+}
+
+// NewRequestID 生成请求ID
+func NewRequestID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
 }
 
 // Info 详情
 func Info(args ...interface{}) {
-	Logger.SetPrefix("[INFO]")
-	Logger.Println(args...)
+	std.log(LevelInfo, args...)
 }
 
 // Danger 错误 为什么不命名为 error？避免和 error 类型重名
 func Danger(args ...interface{}) {
-	Logger.SetPrefix("[ERROR]")
-	Logger.Println(args...)
+	std.log(LevelError, args...)
 }
 
 // Warning 警告
 func Warning(args ...interface{}) {
-	Logger.SetPrefix("[WARNING]")
-	Logger.Println(args...)
+	std.log(LevelWarning, args...)
 }
 
 // DeBug debug
 func DeBug(args ...interface{}) {
-	Logger.SetPrefix("[DEBUG]")
-	Logger.Println(args...)
+	std.log(LevelDebug, args...)
+}
+
+// Info 详情
+func (e *Entry) Info(args ...interface{}) {
+	e.log(LevelInfo, args...)
+}
+
+// Danger 错误
+func (e *Entry) Danger(args ...interface{}) {
+	e.log(LevelError, args...)
+}
+
+// Warning 警告
+func (e *Entry) Warning(args ...interface{}) {
+	e.log(LevelWarning, args...)
+}
+
+// DeBug debug
+func (e *Entry) DeBug(args ...interface{}) {
+	e.log(LevelDebug, args...)
+}
+
+// log 格式化并输出一行日志，每次输出都是完整的一行，不修改共享的状态
+func (e *Entry) log(l int32, args ...interface{}) {
+	if l < atomic.LoadInt32(&level) {
+		return
+	}
+	now := time.Now()
+	message := mask(strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+	caller := "???"
+	if _, file, line, ok := runtime.Caller(2); ok {
+		caller = fmt.Sprintf("%s/%s:%d", filepath.Base(filepath.Dir(file)), filepath.Base(file), line)
+	}
+
+	fields := make(Fields, len(e.fields))
+	for key, value := range e.fields {
+		fields[key] = maskField(key, value)
+	}
+
+	var line []byte
+	if atomic.LoadInt32(&format) == FormatJSON {
+		record := make(map[string]interface{}, len(fields)+4)
+		for key, value := range fields {
+			record[key] = value
+		}
+		record["time"] = now.Format(time.RFC3339Nano)
+		record["level"] = strings.ToLower(levelNames[l])
+		record["msg"] = message
+		record["caller"] = caller
+		data, err := json.Marshal(record)
+		if err != nil {
+			data = []byte(fmt.Sprintf(`{"level":"error","msg":"marshal log error: %v"}`, err))
+		}
+		line = append(data, '\n')
+	} else {
+		var builder strings.Builder
+		builder.WriteString(now.Format("2006/01/02 15:04:05"))
+		builder.WriteString(" [" + levelNames[l] + "] ")
+		builder.WriteString(caller + " ")
+		builder.WriteString(message)
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			builder.WriteString(" " + key + "=" + formatValue(fields[key]))
+		}
+		builder.WriteString("\n")
+		line = []byte(builder.String())
+	}
+
+	writeLock.Lock()
+	defer writeLock.Unlock()
+	_, _ = output.Write(line)
+}
+
+// maskField 隐藏字段中的消息内容和密钥
+func maskField(key string, value interface{}) interface{} {
+	text, ok := value.(string)
+	if !ok {
+		if err, isErr := value.(error); isErr && err != nil {
+			text, ok = err.Error(), true
+		}
+	}
+	if !ok {
+		return value
+	}
+	if contentFields[key] && atomic.LoadInt32(&showContent) == 0 {
+		return fmt.Sprintf("[%d chars]", utf8.RuneCountInString(text))
+	}
+	return mask(text)
+}
+
+// mask 隐藏文本中的密钥
+func mask(text string) string {
+	if list, ok := secrets.Load().([]string); ok {
+		for _, secret := range list {
+			text = strings.ReplaceAll(text, secret, "****")
+		}
+	}
+	for _, pattern := range keyPatterns {
+		text = pattern.ReplaceAllStringFunc(text, func(key string) string {
+			if strings.HasPrefix(key, "Bearer") {
+				return "Bearer ****"
+			}
+			return "sk-****"
+		})
+	}
+	return text
+}
+
+// formatValue 文本格式中的字段值，包含空白或引号时加引号
+func formatValue(value interface{}) string {
+	text := fmt.Sprint(value)
+	if text == "" || strings.ContainsAny(text, " \t\n\"=") {
+		return fmt.Sprintf("%q", text)
+	}
+	return text
 }
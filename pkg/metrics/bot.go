@@ -0,0 +1,29 @@
+package metrics
+
+// 机器人的指标，名称以wechatbot_开头
+var (
+	// MessagesReceived 收到的消息数，type为消息类型，chat为private或group
+	MessagesReceived = NewCounter("wechatbot_messages_received_total", "Messages received from WeChat.", "type", "chat")
+	// MessagesSent 发送的消息数，超长回复分成多条时每条计数一次
+	MessagesSent = NewCounter("wechatbot_messages_sent_total", "Messages sent to WeChat.")
+	// LastReceived 最后收到消息的时间，Unix时间戳，用于发现机器人不再收到消息
+	LastReceived = NewGauge("wechatbot_last_message_received_timestamp_seconds", "Unix time of the last message received.")
+	// LastSent 最后发送回复的时间，Unix时间戳，用于发现机器人不再回复
+	LastSent = NewGauge("wechatbot_last_message_sent_timestamp_seconds", "Unix time of the last reply sent.")
+
+	// GptRequests GPT请求数，result为success、error、canceled
+	GptRequests = NewCounter("wechatbot_gpt_requests_total", "Requests to the GPT API.", "model", "result")
+	// GptLatency GPT请求耗时，包括失败的请求
+	GptLatency = NewHistogram("wechatbot_gpt_request_duration_seconds", "GPT API response time in seconds.", DefaultBuckets, "model")
+	// GptTokens GPT请求的token数，kind为prompt或completion，流式接口不返回用量，为估算值
+	GptTokens = NewCounter("wechatbot_gpt_tokens_total", "Estimated tokens used by GPT requests.", "model", "kind")
+
+	// Errors 错误数，class为错误分类，如gpt_timeout、gpt_error、send_error、queue_full
+	Errors = NewCounter("wechatbot_errors_total", "Errors by class.", "class")
+	// QueueDepth 排队中等待回复的消息数
+	QueueDepth = NewGaugeFunc("wechatbot_queue_depth", "Messages waiting in the queue for a reply.", nil)
+	// LoggedIn 微信是否已登录，1为已登录
+	LoggedIn = NewGauge("wechatbot_logged_in", "Whether the bot is logged in to WeChat.")
+	// LLMReachable 最近一次检查GPT服务是否可以访问，1为可以访问
+	LLMReachable = NewGauge("wechatbot_llm_reachable", "Whether the GPT API was reachable at the last check.")
+)
@@ -0,0 +1,270 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets 默认的直方图分桶，单位秒，覆盖GPT请求从几百毫秒到几分钟的耗时
+var DefaultBuckets = []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120}
+
+// collector 一个指标，按Prometheus文本格式输出
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+var (
+	registry []collector
+	lock     sync.Mutex
+)
+
+// register 注册指标，按注册顺序输出
+func register(c collector) {
+	lock.Lock()
+	defer lock.Unlock()
+	registry = append(registry, c)
+}
+
+// Handler 输出所有指标，格式为Prometheus文本格式
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writer := bufio.NewWriter(w)
+		lock.Lock()
+		collectors := append([]collector(nil), registry...)
+		lock.Unlock()
+		for _, c := range collectors {
+			c.write(writer)
+		}
+		_ = writer.Flush()
+	})
+}
+
+// desc 指标的名称、说明和标签
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+// header 输出指标的说明和类型
+func (d *desc) header(w *bufio.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, kind)
+}
+
+// key 把标签值拼接为map的key，标签值数量和标签数量不一致时panic，属于代码错误
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s expects %d labels, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelText 输出标签，如{model="gpt-3.5-turbo",result="success"}，extra为直方图的le等额外标签
+func (d *desc) labelText(key string, extra ...string) string {
+	pairs := make([]string, 0, len(d.labels)+1)
+	if len(d.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, d.labels[i]+"="+strconv.Quote(value))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+strconv.Quote(extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// sortedKeys map的key排序后输出，保证每次输出的顺序一致
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatFloat 格式化数值
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Counter 只增不减的计数，如收到的消息数
+type Counter struct {
+	desc
+	lock   sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter 创建并注册计数，labels为标签名称，没有标签时初始值为0
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name: name, help: help, labels: labels}, values: make(map[string]float64)}
+	if len(labels) == 0 {
+		c.values[""] = 0
+	}
+	register(c)
+	return c
+}
+
+// Inc 计数加1，values为标签值，顺序和创建时的标签一致
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add 计数增加delta
+func (c *Counter) Add(delta float64, values ...string) {
+	key := c.key(values)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.values[key] += delta
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.header(w, "counter")
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelText(key), formatFloat(c.values[key]))
+	}
+}
+
+// Gauge 可增可减的数值，如是否已登录、最后收到消息的时间
+type Gauge struct {
+	desc
+	lock   sync.Mutex
+	values map[string]float64
+}
+
+// NewGauge 创建并注册数值，labels为标签名称，没有标签时初始值为0
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{desc: desc{name: name, help: help, labels: labels}, values: make(map[string]float64)}
+	if len(labels) == 0 {
+		g.values[""] = 0
+	}
+	register(g)
+	return g
+}
+
+// Set 设置数值
+func (g *Gauge) Set(value float64, values ...string) {
+	key := g.key(values)
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.values[key] = value
+}
+
+// Get 获取数值，没有设置过时返回0
+func (g *Gauge) Get(values ...string) float64 {
+	key := g.key(values)
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.values[key]
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.header(w, "gauge")
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, key := range sortedKeys(g.values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelText(key), formatFloat(g.values[key]))
+	}
+}
+
+// GaugeFunc 输出时调用函数获取的数值，如队列长度
+type GaugeFunc struct {
+	desc
+	lock     sync.Mutex
+	function func() float64
+}
+
+// NewGaugeFunc 创建并注册数值，function为nil时不输出数值，可以之后通过Set设置
+func NewGaugeFunc(name, help string, function func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help}, function: function}
+	register(g)
+	return g
+}
+
+// Set 设置获取数值的函数
+func (g *GaugeFunc) Set(function func() float64) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.function = function
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.lock.Lock()
+	function := g.function
+	g.lock.Unlock()
+	g.header(w, "gauge")
+	if function != nil {
+		fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(function()))
+	}
+}
+
+// Histogram 分桶统计的分布，如GPT请求耗时
+type Histogram struct {
+	desc
+	buckets []float64
+	lock    sync.Mutex
+	// 每组标签值在每个分桶中的数量，最后一个为+Inf
+	counts map[string][]uint64
+	sums   map[string]float64
+}
+
+// NewHistogram 创建并注册直方图，buckets为分桶上限，从小到大排列
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name: name, help: help, labels: labels},
+		buckets: buckets,
+		counts:  make(map[string][]uint64),
+		sums:    make(map[string]float64),
+	}
+	register(h)
+	return h
+}
+
+// Observe 记录一个数值
+func (h *Histogram) Observe(value float64, values ...string) {
+	key := h.key(values)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	counts, ok := h.counts[key]
+	if !ok {
+		counts = make([]uint64, len(h.buckets)+1)
+		h.counts[key] = counts
+	}
+	index := sort.SearchFloat64s(h.buckets, value)
+	counts[index]++
+	h.sums[key] += value
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.header(w, "histogram")
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, key := range sortedKeys(h.sums) {
+		var total uint64
+		for i, count := range h.counts[key] {
+			total += count
+			le := math.Inf(1)
+			if i < len(h.buckets) {
+				le = h.buckets[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelText(key, "le", formatFloat(le)), total)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelText(key), formatFloat(h.sums[key]))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelText(key), total)
+	}
+}
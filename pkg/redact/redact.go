@@ -0,0 +1,145 @@
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 敏感信息类型，同时作为占位符的名称
+const (
+	KindPhone    = "手机号"
+	KindIDCard   = "身份证号"
+	KindBankCard = "银行卡号"
+	KindEmail    = "邮箱"
+)
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	// 手机号，可能带+86或86前缀，可能按3-4-4用空格或者-分隔，如+86 138-1234-5678
+	phoneTextPattern = regexp.MustCompile(`(?:\+?86[ \-]?)?1[3-9]\d(?:[ \-]?\d{4}){2}`)
+	// 连续的数字，身份证号最后一位可能是X，银行卡号可能每4位用空格或者-分隔
+	numberPattern = regexp.MustCompile(`\d{4}(?:[ \-]\d{4}){3}(?:[ \-]\d{1,4})?|\d{11,19}[Xx]?`)
+	phonePattern  = regexp.MustCompile(`^1[3-9]\d{9}$`)
+	idCardPattern = regexp.MustCompile(`^[1-9]\d{5}(18|19|20)\d{2}(0[1-9]|1[0-2])(0[1-9]|[12]\d|3[01])\d{3}[\dXx]$`)
+)
+
+// Redactor 把文本中的手机号、身份证号、银行卡号、邮箱替换为占位符，并支持把占位符还原
+// 同一个Redactor中相同的内容使用相同的占位符，nil时不做任何处理
+type Redactor struct {
+	// 占位符对应的原文
+	originals map[string]string
+	// 原文对应的占位符
+	placeholders map[string]string
+	// 每种类型已经使用的占位符数量
+	counts map[string]int
+}
+
+// New 创建Redactor
+func New() *Redactor {
+	return &Redactor{
+		originals:    make(map[string]string),
+		placeholders: make(map[string]string),
+		counts:       make(map[string]int),
+	}
+}
+
+// Redact 把文本中的敏感信息替换为占位符，如[手机号1]
+func (r *Redactor) Redact(text string) string {
+	if r == nil || text == "" {
+		return text
+	}
+	text = emailPattern.ReplaceAllStringFunc(text, func(email string) string {
+		return r.placeholder(KindEmail, email)
+	})
+
+	// 先处理带前缀和分隔符的手机号，再处理其他数字串
+	text = r.replaceNumbers(text, phoneTextPattern, func(string) string { return KindPhone })
+	return r.replaceNumbers(text, numberPattern, classify)
+}
+
+// replaceNumbers 把pattern匹配到的数字串按classify的类型替换为占位符
+// 只处理前后都不是数字的完整数字串，避免误伤更长的编号
+func (r *Redactor) replaceNumbers(text string, pattern *regexp.Regexp, classify func(number string) string) string {
+	matches := pattern.FindAllStringIndex(text, -1)
+	var builder strings.Builder
+	last := 0
+	for _, match := range matches {
+		start, end := match[0], match[1]
+		if (start > 0 && (isDigit(text[start-1]) || text[start-1] == '+')) || (end < len(text) && isDigit(text[end])) {
+			continue
+		}
+		kind := classify(text[start:end])
+		if kind == "" {
+			continue
+		}
+		builder.WriteString(text[last:start])
+		builder.WriteString(r.placeholder(kind, text[start:end]))
+		last = end
+	}
+	builder.WriteString(text[last:])
+	return builder.String()
+}
+
+// Restore 把文本中的占位符还原为原文
+func (r *Redactor) Restore(text string) string {
+	if r == nil || len(r.originals) == 0 {
+		return text
+	}
+	pairs := make([]string, 0, len(r.originals)*2)
+	for placeholder, original := range r.originals {
+		pairs = append(pairs, placeholder, original)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// placeholder 获取原文对应的占位符，没有时新建
+func (r *Redactor) placeholder(kind, original string) string {
+	if placeholder, ok := r.placeholders[original]; ok {
+		return placeholder
+	}
+	r.counts[kind]++
+	placeholder := fmt.Sprintf("[%s%d]", kind, r.counts[kind])
+	r.placeholders[original] = placeholder
+	r.originals[placeholder] = original
+	return placeholder
+}
+
+// classify 判断数字串的类型，不是敏感信息时返回空字符串
+func classify(number string) string {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(number)
+	switch {
+	case phonePattern.MatchString(digits):
+		return KindPhone
+	case idCardPattern.MatchString(digits):
+		return KindIDCard
+	case len(digits) >= 16 && len(digits) <= 19 && luhn(digits):
+		return KindBankCard
+	}
+	return ""
+}
+
+// luhn 银行卡号Luhn校验
+func luhn(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		if !isDigit(number[i]) {
+			return false
+		}
+		digit := int(number[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
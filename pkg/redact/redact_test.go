@@ -0,0 +1,73 @@
+package redact
+
+import "testing"
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"phone", "我的手机号13812345678", "我的手机号[手机号1]"},
+		{"phone with spaces", "电话 138 1234 5678 谢谢", "电话 [手机号1] 谢谢"},
+		{"phone with dashes", "电话138-1234-5678", "电话[手机号1]"},
+		{"phone with +86", "call +8613812345678", "call [手机号1]"},
+		{"phone with +86 and space", "call +86 138-1234-5678.", "call [手机号1]."},
+		{"phone with 86", "8613812345678", "[手机号1]"},
+		{"same phone same placeholder", "13812345678和13812345678", "[手机号1]和[手机号1]"},
+		{"two phones", "13812345678、13912345678", "[手机号1]、[手机号2]"},
+		{"id card", "身份证110101199003071234", "身份证[身份证号1]"},
+		{"id card with x", "身份证11010119900307123X。", "身份证[身份证号1]。"},
+		{"bank card", "卡号6222021234567890128", "卡号[银行卡号1]"},
+		{"bank card with spaces", "卡号 4111 1111 1111 1111", "卡号 [银行卡号1]"},
+		{"bank card with dashes", "卡号4111-1111-1111-1111", "卡号[银行卡号1]"},
+		{"email", "邮箱test.user+bot@example.com", "邮箱[邮箱1]"},
+		{"mixed", "13812345678 a@b.cn 110101199003071234", "[手机号1] [邮箱1] [身份证号1]"},
+		{"order number", "订单号20230301123456785", "订单号20230301123456785"},
+		{"phone inside longer number", "流水号9138123456781", "流水号9138123456781"},
+		{"short number", "验证码123456", "验证码123456"},
+		{"invalid phone prefix", "12812345678", "12812345678"},
+		{"invalid card checksum", "4111111111111112", "4111111111111112"},
+		{"invalid id card date", "110101199013071234", "110101199013071234"},
+		{"empty", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := New().Redact(test.text); got != test.want {
+				t.Errorf("Redact(%q) = %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	texts := []string{
+		"我的手机号是138 1234 5678，邮箱a@b.cn，身份证110101199003071234，卡号4111-1111-1111-1111",
+		"+8613812345678和13812345678",
+		"没有隐私信息",
+	}
+	for _, text := range texts {
+		r := New()
+		redacted := r.Redact(text)
+		if got := r.Restore(redacted); got != text {
+			t.Errorf("Restore(Redact(%q)) = %q", text, got)
+		}
+	}
+
+	// GPT回复中引用的占位符同样还原
+	r := New()
+	r.Redact("13812345678")
+	if got := r.Restore("已记录[手机号1]"); got != "已记录13812345678" {
+		t.Errorf("Restore reply = %q", got)
+	}
+}
+
+func TestNilRedactor(t *testing.T) {
+	var r *Redactor
+	if got := r.Redact("13812345678"); got != "13812345678" {
+		t.Errorf("nil Redact = %q", got)
+	}
+	if got := r.Restore("[手机号1]"); got != "[手机号1]" {
+		t.Errorf("nil Restore = %q", got)
+	}
+}
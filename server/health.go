@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/pkg/metrics"
+)
+
+// 微信登录状态
+const (
+	// LoginWaiting 启动后等待扫码登录
+	LoginWaiting = "waiting"
+	// LoginOnline 已登录
+	LoginOnline = "logged_in"
+	// LoginOffline 登录后掉线或者在手机上退出了登录
+	LoginOffline = "logged_out"
+)
+
+// llmCheckTimeout 检查GPT服务的超时时间
+const llmCheckTimeout = 10 * time.Second
+
+// llmStatus GPT服务的检查结果
+type llmStatus struct {
+	// 是否检查GPT服务，llm_check_interval为0时不检查
+	Enabled bool `json:"enabled"`
+	// 最近一次检查是否可以访问
+	Reachable bool `json:"reachable"`
+	// 最近一次检查的时间，还没有检查过时为空
+	CheckedAt *time.Time `json:"checked_at"`
+	// 最近一次检查的错误
+	Error string `json:"error,omitempty"`
+}
+
+// healthStatus /healthz、/readyz返回的状态
+type healthStatus struct {
+	// ok或者unavailable
+	Status string `json:"status"`
+	// 微信登录状态：waiting、logged_in、logged_out
+	Login string `json:"login"`
+	// 最后收到消息的时间，还没有收到过时为空
+	LastReceived *time.Time `json:"last_received"`
+	// 最后发送消息的时间，还没有发送过时为空
+	LastSent *time.Time `json:"last_sent"`
+	// GPT服务的状态
+	LLM llmStatus `json:"llm"`
+}
+
+var (
+	loginState = LoginWaiting
+	llm        llmStatus
+	stateLock  sync.RWMutex
+)
+
+// SetLoginState 更新微信登录状态，同时更新wechatbot_logged_in指标
+func SetLoginState(state string) {
+	stateLock.Lock()
+	loginState = state
+	stateLock.Unlock()
+	if state == LoginOnline {
+		metrics.LoggedIn.Set(1)
+	} else {
+		metrics.LoggedIn.Set(0)
+	}
+}
+
+// currentStatus 当前的状态
+func currentStatus() healthStatus {
+	stateLock.RLock()
+	status := healthStatus{Login: loginState, LLM: llm}
+	stateLock.RUnlock()
+	status.LLM.Enabled = config.LoadConfig().HTTP.LLMCheckInterval > 0
+	status.LastReceived = unixTime(metrics.LastReceived.Get())
+	status.LastSent = unixTime(metrics.LastSent.Get())
+	return status
+}
+
+// unixTime 把指标中的Unix时间戳转换为时间，为0时返回nil
+func unixTime(seconds float64) *time.Time {
+	if seconds == 0 {
+		return nil
+	}
+	t := time.Unix(int64(seconds), 0)
+	return &t
+}
+
+// healthz 存活检查，登录后掉线时返回503，进程还在运行但已经不能收发消息，需要重启并重新登录；等待扫码时返回200
+func healthz(w http.ResponseWriter, r *http.Request) {
+	status := currentStatus()
+	writeStatus(w, status, status.Login != LoginOffline)
+}
+
+// readyz 就绪检查，已登录并且GPT服务可以访问时返回200
+func readyz(w http.ResponseWriter, r *http.Request) {
+	status := currentStatus()
+	writeStatus(w, status, status.Login == LoginOnline && (!status.LLM.Enabled || status.LLM.Reachable))
+}
+
+// writeStatus 输出状态，ok为false时返回503
+func writeStatus(w http.ResponseWriter, status healthStatus, ok bool) {
+	code := http.StatusOK
+	status.Status = "ok"
+	if !ok {
+		code = http.StatusServiceUnavailable
+		status.Status = "unavailable"
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// checkLLM 按配置的间隔检查GPT服务能否访问，间隔修改后下一次检查生效
+func checkLLM() {
+	for {
+		interval := time.Duration(config.LoadConfig().HTTP.LLMCheckInterval)
+		if interval <= 0 {
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), llmCheckTimeout)
+		err := gpt.Ping(ctx)
+		cancel()
+		now := time.Now()
+		stateLock.Lock()
+		if err != nil && (llm.CheckedAt == nil || llm.Reachable) {
+			logger.Warning("gpt service unreachable: " + err.Error())
+		}
+		llm = llmStatus{Reachable: err == nil, CheckedAt: &now}
+		if err != nil {
+			llm.Error = err.Error()
+		}
+		stateLock.Unlock()
+		if err == nil {
+			metrics.LLMReachable.Set(1)
+		} else {
+			metrics.LLMReachable.Set(0)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/pkg/metrics"
+)
+
+// Start 按配置启动HTTP监听，没有配置监听地址时不启动
+// /metrics提供Prometheus格式的监控指标，/healthz、/readyz提供存活检查和就绪检查
+func Start() error {
+	addr := config.LoadConfig().HTTP.Listen
+	if addr == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/readyz", readyz)
+
+	// 先监听再返回，端口被占用等错误在启动时就能发现
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen %s error: %v", addr, err)
+	}
+	logger.Info(fmt.Sprintf("http server listening on %s", listener.Addr()))
+	go checkLLM()
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			logger.Danger(fmt.Sprintf("http server error: %v", err))
+		}
+	}()
+	return nil
+}
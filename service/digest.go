@@ -0,0 +1,52 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/pkg/redact"
+)
+
+// digestMaxLength 发送给GPT的群聊记录最大字符数，超出时只保留最近的消息
+const digestMaxLength = 3000
+
+// digestPrompt 生成群聊摘要的提示词
+const digestPrompt = "下面是一个微信群最近的聊天记录，每行格式为“[时间] 发送者：内容”。" +
+	"请用中文整理一份简洁的摘要，包括：1.讨论了哪些话题；2.谁提了什么问题；3.还没有得到解答的问题。\n聊天记录：\n"
+
+// ErrNoGroupMessages 指定时间范围内没有群消息
+var ErrNoGroupMessages = errors.New("no group messages")
+
+// SummarizeGroupMessages 使用GPT总结群里since之后的聊天记录
+func (s *GroupService) SummarizeGroupMessages(since time.Time) (string, error) {
+	messages := groupMessagesSince(s.groupID(), since)
+	if len(messages) == 0 {
+		return "", ErrNoGroupMessages
+	}
+
+	// 从最新的消息往前取，直到超出最大字符数
+	lines := make([]string, 0, len(messages))
+	length := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		message := messages[i]
+		line := "[" + message.Time.Format("01-02 15:04") + "] " + message.Sender + "：" + message.Content
+		if length+len(line) > digestMaxLength && len(lines) > 0 {
+			break
+		}
+		length += len(line)
+		lines = append(lines, line)
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+
+	// 群聊记录中的隐私信息替换为占位符，摘要发到群里，不还原
+	prompt := digestPrompt + strings.Join(lines, "\n")
+	if config.LoadConfig().Redaction.Enable {
+		prompt = redact.New().Redact(prompt)
+	}
+	return gpt.Completions(prompt)
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+)
+
+// SessionRecord 导出的会话
+type SessionRecord struct {
+	// 缓存key，私聊为用户ID，群共享会话为group:群ID
+	Key string `json:"key"`
+	// 会话类型：user私聊或群里的独立会话，group群共享会话
+	Type string `json:"type"`
+	// 过期时间
+	ExpiresAt time.Time `json:"expires_at"`
+	// 会话中的消息
+	Messages []gpt.Message `json:"messages"`
+}
+
+// ExportSessions 导出缓存中没有过期的会话
+func ExportSessions(c *cache.Cache) []SessionRecord {
+	records := make([]SessionRecord, 0)
+	for key, item := range c.Items() {
+		record := SessionRecord{Key: key, Type: "user"}
+		if item.Expiration > 0 {
+			record.ExpiresAt = time.Unix(0, item.Expiration)
+		}
+		switch session := item.Object.(type) {
+		case []SessionTurn:
+			for _, turn := range session {
+				record.Messages = append(record.Messages,
+					gpt.Message{Role: "user", Content: turn.Question},
+					gpt.Message{Role: "assistant", Content: turn.Reply})
+			}
+		case []groupSessionMessage:
+			record.Type = "group"
+			for _, message := range session {
+				record.Messages = append(record.Messages, message.message)
+			}
+		default:
+			continue
+		}
+		records = append(records, record)
+	}
+	return records
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/patrickmn/go-cache"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/rule"
+)
+
+// groupSessionPrefix 群共享会话在缓存中的key前缀，避免和用户会话冲突
+const groupSessionPrefix = "group:"
+
+// GroupServiceInterface 群业务接口
+type GroupServiceInterface interface {
+	IsSharedSession() bool
+	SetSharedSession(shared bool)
+	GetGroupSessionContext() []gpt.Message
+	AppendGroupSessionContext(msgID string, messages ...gpt.Message)
+	RemoveGroupSessionContext(msgID string) bool
+	ClearGroupSessionContext()
+	RecordGroupMessage(msgID, sender, content string, createTime time.Time)
+	SummarizeGroupMessages(since time.Time) (string, error)
+}
+
+var _ GroupServiceInterface = (*GroupService)(nil)
+
+// sharedModes 群会话模式，key为群ID，true为全群共享会话，false为每个人独立会话
+var sharedModes = make(map[string]bool)
+var modeLock sync.RWMutex
+
+// groupSessionMessage 群共享会话中的消息
+type groupSessionMessage struct {
+	// 对应的微信消息ID，撤回消息时用于删除
+	msgID string
+	// 发送给GPT的消息
+	message gpt.Message
+}
+
+// GroupService 群业务
+type GroupService struct {
+	// 缓存
+	cache *cache.Cache
+	// 群
+	group *openwechat.Group
+}
+
+// NewGroupService 创建新的群业务层
+func NewGroupService(cache *cache.Cache, group *openwechat.Group) GroupServiceInterface {
+	return &GroupService{
+		cache: cache,
+		group: group,
+	}
+}
+
+// groupID 获取群的唯一标识，获取不到时使用本次登录有效的UserName
+func (s *GroupService) groupID() string {
+	if id := s.group.ID(); id != "" {
+		return id
+	}
+	return s.group.UserName
+}
+
+// IsSharedSession 是否为全群共享会话，没有通过指令切换过时，使用配置中的默认值
+func (s *GroupService) IsSharedSession() bool {
+	modeLock.RLock()
+	shared, ok := sharedModes[s.groupID()]
+	modeLock.RUnlock()
+	if ok {
+		return shared
+	}
+	return rule.Grule.InSlice(s.group.NickName, config.LoadConfig().SharedSessionGroups)
+}
+
+// SetSharedSession 切换群会话模式，切换后清空群共享会话
+func (s *GroupService) SetSharedSession(shared bool) {
+	modeLock.Lock()
+	sharedModes[s.groupID()] = shared
+	modeLock.Unlock()
+	s.ClearGroupSessionContext()
+}
+
+// GetGroupSessionContext 获取群共享会话上下文
+func (s *GroupService) GetGroupSessionContext() []gpt.Message {
+	session := s.getSession()
+	messages := make([]gpt.Message, 0, len(session))
+	for _, item := range session {
+		messages = append(messages, item.message)
+	}
+	return messages
+}
+
+// AppendGroupSessionContext 追加群共享会话上下文，msgID为对应的微信消息ID，总字符长度超过4000时丢弃最早的消息
+func (s *GroupService) AppendGroupSessionContext(msgID string, messages ...gpt.Message) {
+	// 复制一份再追加，避免修改缓存中正在被读取的切片
+	session := append([]groupSessionMessage(nil), s.getSession()...)
+	for _, message := range messages {
+		session = append(session, groupSessionMessage{msgID: msgID, message: message})
+	}
+	length := 0
+	for _, item := range session {
+		length += len(item.message.Content)
+	}
+	for len(session) > 0 && length >= 4000 {
+		length -= len(session[0].message.Content)
+		session = session[1:]
+	}
+	s.cache.Set(groupSessionPrefix+s.groupID(), session, time.Duration(config.LoadConfig().SessionTimeout))
+}
+
+// RemoveGroupSessionContext 删除微信消息对应的群共享会话上下文，用户撤回消息时调用，返回是否删除成功
+func (s *GroupService) RemoveGroupSessionContext(msgID string) bool {
+	session := s.getSession()
+	kept := make([]groupSessionMessage, 0, len(session))
+	for _, item := range session {
+		if item.msgID != msgID {
+			kept = append(kept, item)
+		}
+	}
+	if len(kept) == len(session) {
+		return false
+	}
+	s.cache.Set(groupSessionPrefix+s.groupID(), kept, time.Duration(config.LoadConfig().SessionTimeout))
+	return true
+}
+
+// getSession 获取群共享会话中的消息
+func (s *GroupService) getSession() []groupSessionMessage {
+	sessionContext, ok := s.cache.Get(groupSessionPrefix + s.groupID())
+	if !ok {
+		return nil
+	}
+	return sessionContext.([]groupSessionMessage)
+}
+
+// ClearGroupSessionContext 清空群共享会话上下文
+func (s *GroupService) ClearGroupSessionContext() {
+	s.cache.Delete(groupSessionPrefix + s.groupID())
+}
+
+// RecordGroupMessage 记录群消息，包括没有@机器人的消息，用于生成群聊摘要
+func (s *GroupService) RecordGroupMessage(msgID, sender, content string, createTime time.Time) {
+	message := GroupMessage{MsgID: msgID, Time: createTime, Sender: sender, Content: content}
+	recordGroupMessage(s.groupID(), message, config.LoadConfig().GroupBufferSize)
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// GroupMessage 群消息记录，用于生成群聊摘要
+type GroupMessage struct {
+	// 微信消息ID，撤回消息时用于删除
+	MsgID string
+	// 发送时间
+	Time time.Time
+	// 发送者在群里的名称
+	Sender string
+	// 消息内容
+	Content string
+}
+
+// groupBuffers 每个群最近的消息，key为群ID
+var groupBuffers = make(map[string][]GroupMessage)
+var bufferLock sync.RWMutex
+
+// recordGroupMessage 记录群消息，每个群最多保留size条，超出时丢弃最早的消息
+func recordGroupMessage(groupID string, message GroupMessage, size int) {
+	if size <= 0 {
+		return
+	}
+	bufferLock.Lock()
+	defer bufferLock.Unlock()
+	buffer := append(groupBuffers[groupID], message)
+	if len(buffer) > size {
+		// 重新分配切片，避免底层数组无限增长
+		buffer = append([]GroupMessage(nil), buffer[len(buffer)-size:]...)
+	}
+	groupBuffers[groupID] = buffer
+}
+
+// RemoveGroupMessage 从群消息记录中删除指定消息ID的消息，用户撤回消息时调用，避免被撤回的内容出现在群聊摘要中
+// 消息ID全局唯一，撤回时不需要获取群，返回是否删除成功
+func RemoveGroupMessage(msgID string) bool {
+	bufferLock.Lock()
+	defer bufferLock.Unlock()
+	for groupID, buffer := range groupBuffers {
+		for i, message := range buffer {
+			if message.MsgID == msgID {
+				groupBuffers[groupID] = append(buffer[:i:i], buffer[i+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// groupMessagesSince 获取群里since之后的消息，按时间先后排列
+func groupMessagesSince(groupID string, since time.Time) []GroupMessage {
+	bufferLock.RLock()
+	defer bufferLock.RUnlock()
+	buffer := groupBuffers[groupID]
+	for i, message := range buffer {
+		if message.Time.After(since) {
+			return append([]GroupMessage(nil), buffer[i:]...)
+		}
+	}
+	return nil
+}
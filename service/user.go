@@ -1,21 +1,34 @@
 package service
 
 import (
-	"github.com/qingconglaixueit/wechatbot/config"
+	"strings"
+	"time"
+
 	"github.com/eatmoreapple/openwechat"
 	"github.com/patrickmn/go-cache"
-	"time"
+	"github.com/qingconglaixueit/wechatbot/config"
 )
 
 // UserServiceInterface 用户业务接口
 type UserServiceInterface interface {
 	GetUserSessionContext() string
-	SetUserSessionContext(question, reply string)
+	SetUserSessionContext(msgID, question, reply string)
+	RemoveUserSessionContext(msgID string) bool
 	ClearUserSessionContext()
 }
 
 var _ UserServiceInterface = (*UserService)(nil)
 
+// SessionTurn 一轮会话
+type SessionTurn struct {
+	// 提问的消息ID，撤回消息时用于删除这一轮会话
+	MsgID string
+	// 用户提问内容
+	Question string
+	// GPT回复内容
+	Reply string
+}
+
 // UserService 用戶业务
 type UserService struct {
 	// 缓存
@@ -40,23 +53,60 @@ func (s *UserService) ClearUserSessionContext() {
 // GetUserSessionContext 获取用户会话上下文文本
 func (s *UserService) GetUserSessionContext() string {
 	// 1.获取上次会话信息，如果没有直接返回空字符串
-	sessionContext, ok := s.cache.Get(s.user.ID())
-	if !ok {
+	turns := s.getTurns()
+	if len(turns) == 0 {
 		return ""
 	}
 
-	// 2.如果字符长度超过等于4000，强制清空会话（超过GPT会报错）。
-	contextText := sessionContext.(string)
+	// 2.拼接每一轮的提问和回复
+	texts := make([]string, 0, len(turns)*2)
+	for _, turn := range turns {
+		texts = append(texts, turn.Question, turn.Reply)
+	}
+	contextText := strings.Join(texts, "\n")
+
+	// 3.如果字符长度超过等于4000，强制清空会话（超过GPT会报错）。
 	if len(contextText) >= 4000 {
 		s.cache.Delete(s.user.ID())
 	}
 
-	// 3.返回上文
+	// 4.返回上文
 	return contextText
 }
 
 // SetUserSessionContext 设置用户会话上下文文本，question用户提问内容，GTP回复内容
-func (s *UserService) SetUserSessionContext(question, reply string) {
-	value := question + "\n" + reply
-	s.cache.Set(s.user.ID(), value, time.Second*config.LoadConfig().SessionTimeout)
+func (s *UserService) SetUserSessionContext(msgID, question, reply string) {
+	// 复制一份再追加，避免修改缓存中正在被读取的切片
+	turns := append(append([]SessionTurn(nil), s.getTurns()...), SessionTurn{MsgID: msgID, Question: question, Reply: reply})
+	s.setTurns(turns)
+}
+
+// RemoveUserSessionContext 删除消息对应的一轮会话，用户撤回提问时调用，返回是否删除成功
+func (s *UserService) RemoveUserSessionContext(msgID string) bool {
+	turns := s.getTurns()
+	for i, turn := range turns {
+		if turn.MsgID == msgID {
+			s.setTurns(append(turns[:i:i], turns[i+1:]...))
+			return true
+		}
+	}
+	return false
+}
+
+// getTurns 获取用户的每一轮会话
+func (s *UserService) getTurns() []SessionTurn {
+	sessionContext, ok := s.cache.Get(s.user.ID())
+	if !ok {
+		return nil
+	}
+	return sessionContext.([]SessionTurn)
+}
+
+// setTurns 保存用户的每一轮会话，没有会话时删除缓存
+func (s *UserService) setTurns(turns []SessionTurn) {
+	if len(turns) == 0 {
+		s.cache.Delete(s.user.ID())
+		return
+	}
+	s.cache.Set(s.user.ID(), turns, time.Duration(config.LoadConfig().SessionTimeout))
 }